@@ -0,0 +1,143 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"idv-login-go/app"
+	"idv-login-go/config"
+	"idv-login-go/constants"
+	"idv-login-go/logger"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var log *logrus.Logger
+var conf *config.Config
+
+// Server 本地管理接口，只监听回环地址，请求需要携带令牌
+// 令牌通过 Authorization: Bearer <令牌> 或 X-Admin-Token 请求头传递
+type Server struct {
+	app        *app.App
+	token      string
+	started    time.Time
+	httpServer *http.Server
+}
+
+func New(a *app.App) *Server {
+	log = logger.GetLogger()
+	conf = config.GetConfig()
+	registerMetrics(a)
+	return &Server{app: a, started: time.Now()}
+}
+
+// Start 在后台启动管理接口，监听地址不是回环地址时返回错误
+func (s *Server) Start() error {
+	addr := conf.String("adminListen")
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	token, err := loadToken()
+	if err != nil {
+		return fmt.Errorf("准备管理接口令牌失败：%w", err)
+	}
+	s.token = token
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("管理接口监听 %s 失败：%w", addr, err)
+	}
+
+	if !constants.DebugMode {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	engine := gin.New()
+	engine.Use(gin.Recovery(), s.auth)
+	s.setupRoutes(engine)
+	s.httpServer = &http.Server{Handler: engine, ReadHeaderTimeout: 10 * time.Second}
+
+	srv := s.httpServer
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("管理接口运行失败：%v", err)
+		}
+	}()
+	log.Infof("管理接口已启动：http://%s/api/status", ln.Addr())
+	return nil
+}
+
+// Shutdown 关闭管理接口
+func (s *Server) Shutdown() error {
+	if s.httpServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// checkLoopback 监听地址只能是回环地址
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("管理接口监听地址 %s 无效：%w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("管理接口只能监听回环地址，%s 不是回环地址", addr)
+	}
+	return nil
+}
+
+// loadToken 使用配置的令牌，未配置时读取上次生成的令牌，没有则生成新令牌并写入文件
+func loadToken() (string, error) {
+	if token := conf.String("adminToken"); token != "" {
+		return token, nil
+	}
+	if data, err := os.ReadFile(constants.AdminTokenPath); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(constants.AdminTokenPath, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Infof("已生成管理接口令牌，保存在 %s", constants.AdminTokenPath)
+	return token, nil
+}
+
+// auth 只允许本机携带正确令牌的请求
+func (s *Server) auth(c *gin.Context) {
+	if host, _, err := net.SplitHostPort(c.Request.RemoteAddr); err != nil || !net.ParseIP(host).IsLoopback() {
+		abort(c, http.StatusForbidden, errors.New("只允许本机访问"))
+		return
+	}
+	token := c.GetHeader("X-Admin-Token")
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		abort(c, http.StatusUnauthorized, errors.New("令牌无效"))
+		return
+	}
+	c.Next()
+}
+
+func abort(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, gin.H{"reason": err.Error()})
+}
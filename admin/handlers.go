@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"idv-login-go/app"
+	"idv-login-go/certController"
+	"idv-login-go/constants"
+	"idv-login-go/hostsController"
+	"idv-login-go/logger"
+	"idv-login-go/server"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+func (s *Server) setupRoutes(g *gin.Engine) {
+	g.GET("/metrics", s.handleMetrics)
+	api := g.Group("/api")
+	api.GET("/status", s.handleStatus)
+	api.POST("/start", s.handleControl((*app.App).Start))
+	api.POST("/stop", s.handleControl((*app.App).Stop))
+	api.POST("/restart", s.handleControl((*app.App).Restart))
+	api.POST("/config/reload", s.handleControl((*app.App).Reload))
+	api.GET("/logs", s.handleLogs)
+	api.GET("/requests", s.handleRequests)
+}
+
+// Status 运行状态
+type Status struct {
+	State        app.State     `json:"state"`
+	Error        string        `json:"error,omitempty"`
+	Since        time.Time     `json:"since"`        // 进入当前状态的时间
+	Uptime       float64       `json:"uptime"`       // 程序运行时间（秒）
+	StateUptime  float64       `json:"stateUptime"`  // 处于当前状态的时间（秒）
+	RedirectMode string        `json:"redirectMode"` // 重定向方式
+	Intercepted  []string      `json:"intercepted"`  // 拦截的主机名
+	Listen       []string      `json:"listen"`       // 代理服务器的监听地址
+	Hosts        HostsStatus   `json:"hosts"`        // hosts文件
+	Upstreams    []Upstream    `json:"upstreams"`    // 未运行时为空
+	Cert         CertStatus    `json:"cert"`         // 证书
+	Requests     server.Counts `json:"requests"`     // 请求计数
+	ReplayFiles  []string      `json:"replayFiles"`  // 离线回放的HAR文件
+	HarCapture   bool          `json:"harCapture"`   // 是否开启HAR记录
+	Debug        bool          `json:"debug"`        // debug模式
+}
+
+type HostsStatus struct {
+	Exist   bool `json:"exist"`   // 已写入且与当前配置一致
+	Managed bool `json:"managed"` // 已写入
+	Journal bool `json:"journal"` // 存在写入记录，程序正在运行或上次未正常退出
+}
+
+type Upstream struct {
+	Host       string      `json:"host"`
+	Best       string      `json:"best"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+type Candidate struct {
+	IP        string     `json:"ip"`
+	Latency   float64    `json:"latencyMs"`
+	Probes    int        `json:"probes"`
+	Successes int        `json:"successes"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"lastError,omitempty"`
+	State     string     `json:"state"`
+	OpenUntil *time.Time `json:"openUntil,omitempty"` // 熔断冷却结束时间
+}
+
+type CertStatus struct {
+	CANotAfter   *time.Time `json:"caNotAfter,omitempty"`
+	CertNotAfter *time.Time `json:"certNotAfter,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+func (s *Server) status() Status {
+	state, err := s.app.State()
+	since := s.app.Since()
+	hostC := hostsController.New()
+	_, journalErr := os.Stat(constants.HostsJournalPath)
+	status := Status{
+		State:        state,
+		Since:        since,
+		Uptime:       time.Since(s.started).Seconds(),
+		StateUptime:  time.Since(since).Seconds(),
+		RedirectMode: conf.String("redirectMode"),
+		Intercepted:  conf.Hosts(),
+		Listen:       conf.Strings("listen"),
+		Hosts:        HostsStatus{Exist: hostC.Exist(), Managed: hostC.Managed(), Journal: journalErr == nil},
+		Upstreams:    make([]Upstream, 0),
+		Cert:         certStatus(),
+		Requests:     s.app.Stats().Counts(),
+		ReplayFiles:  conf.Strings("replayFiles"),
+		HarCapture:   conf.Bool("harCapture"),
+		Debug:        constants.DebugMode,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	for _, pool := range s.app.Upstreams() {
+		u := Upstream{Host: pool.Host(), Best: pool.Best(), Candidates: make([]Candidate, 0)}
+		for _, c := range pool.Candidates() {
+			candidate := Candidate{
+				IP:        c.IP,
+				Latency:   float64(c.Latency.Microseconds()) / 1000,
+				Probes:    c.Probes,
+				Successes: c.Successes,
+				Failures:  c.Failures,
+				LastError: c.LastError,
+				State:     string(c.State),
+			}
+			if !c.OpenUntil.IsZero() {
+				candidate.OpenUntil = &c.OpenUntil
+			}
+			u.Candidates = append(u.Candidates, candidate)
+		}
+		status.Upstreams = append(status.Upstreams, u)
+	}
+	return status
+}
+
+// certStatus 读取证书文件的有效期，不做完整检查
+func certStatus() CertStatus {
+	var status CertStatus
+	ca, err := certController.LoadCert(constants.CaPath)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.CANotAfter = &ca.NotAfter
+	cert, err := certController.LoadCert(constants.CertPath)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.CertNotAfter = &cert.NotAfter
+	return status
+}
+
+func (s *Server) handleStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, s.status())
+}
+
+// handleControl 执行操作后返回最新状态，失败时返回 500 与原因
+func (s *Server) handleControl(fn func(*app.App) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := fn(s.app); err != nil {
+			abort(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, s.status())
+	}
+}
+
+// handleLogs 最近的日志，limit 默认100
+func (s *Server) handleLogs(c *gin.Context) {
+	c.JSON(http.StatusOK, logger.Recent(limit(c)))
+}
+
+// handleRequests 最近的代理请求，从新到旧，limit 默认100
+func (s *Server) handleRequests(c *gin.Context) {
+	c.JSON(http.StatusOK, s.app.Stats().Recent(limit(c)))
+}
+
+func limit(c *gin.Context) int {
+	n, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || n <= 0 {
+		return 100
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"idv-login-go/app"
+	"idv-login-go/hostsController"
+	"idv-login-go/metrics"
+	"net/http"
+	"time"
+)
+
+var states = []app.State{app.StateStopped, app.StateStarting, app.StateRunning, app.StateStopping, app.StateFailed}
+
+// registerMetrics 注册导出时读取的状态类指标
+func registerMetrics(a *app.App) {
+	metrics.NewGaugeFunc("idv_state", "代理的运行状态，当前状态为1", []string{"state"}, func() []metrics.Sample {
+		current, _ := a.State()
+		samples := make([]metrics.Sample, 0, len(states))
+		for _, state := range states {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			samples = append(samples, metrics.Sample{Values: []string{string(state)}, Value: value})
+		}
+		return samples
+	})
+	metrics.NewGaugeFunc("idv_state_since_seconds", "进入当前状态的时间（Unix时间戳）", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(a.Since().UnixNano()) / 1e9}}
+	})
+	metrics.NewGaugeFunc("idv_cert_expiry_days", "证书距离过期的天数，cert 为 ca 或 leaf，证书不存在时不导出", []string{"cert"}, func() []metrics.Sample {
+		var samples []metrics.Sample
+		status := certStatus()
+		if status.CANotAfter != nil {
+			samples = append(samples, metrics.Sample{Values: []string{"ca"}, Value: time.Until(*status.CANotAfter).Hours() / 24})
+		}
+		if status.CertNotAfter != nil {
+			samples = append(samples, metrics.Sample{Values: []string{"leaf"}, Value: time.Until(*status.CertNotAfter).Hours() / 24})
+		}
+		return samples
+	})
+	metrics.NewGaugeFunc("idv_hosts_state", "hosts中本程序写入的记录：absent 不存在，stale 与当前配置不一致，current 一致，当前状态为1", []string{"state"}, func() []metrics.Sample {
+		hostC := hostsController.New()
+		current := "absent"
+		switch {
+		case hostC.Exist():
+			current = "current"
+		case hostC.Managed():
+			current = "stale"
+		}
+		samples := make([]metrics.Sample, 0, 3)
+		for _, state := range []string{"absent", "stale", "current"} {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			samples = append(samples, metrics.Sample{Values: []string{state}, Value: value})
+		}
+		return samples
+	})
+	metrics.NewGaugeFunc("idv_upstream_latency_seconds", "上游候选IP的TLS握手延迟（秒），未运行时不导出", []string{"host", "ip", "state"}, func() []metrics.Sample {
+		var samples []metrics.Sample
+		for _, pool := range a.Upstreams() {
+			for _, c := range pool.Candidates() {
+				samples = append(samples, metrics.Sample{Values: []string{pool.Host(), c.IP, string(c.State)}, Value: c.Latency.Seconds()})
+			}
+		}
+		return samples
+	})
+}
+
+// handleMetrics Prometheus 格式的指标，抓取时需要在 authorization 中配置令牌
+func (s *Server) handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	if err := metrics.Write(c.Writer); err != nil {
+		log.Warnf("导出指标失败：%v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"idv-login-go/certController"
+	"idv-login-go/config"
+	"idv-login-go/dnsController"
+	"idv-login-go/harController"
+	"idv-login-go/logger"
+	"idv-login-go/rules"
+	"idv-login-go/server"
+	"idv-login-go/upstreamController"
+	"sync"
+	"time"
+)
+
+// State 运行状态
+type State string
+
+const (
+	StateStopped  State = "stopped"  // 未启动
+	StateStarting State = "starting" // 启动中
+	StateRunning  State = "running"  // 运行中
+	StateStopping State = "stopping" // 停止中
+	StateFailed   State = "failed"   // 启动失败或运行中意外退出，已完成的步骤均已回滚
+)
+
+// EventKind 事件类型
+type EventKind string
+
+const (
+	EventState    EventKind = "state"    // 状态变化
+	EventUpstream EventKind = "upstream" // 上游状态变化
+)
+
+// Event 事件，失败时 Err 为 *StepError 或代理服务器的运行错误
+type Event struct {
+	Kind  EventKind
+	State State
+	Err   error
+	Time  time.Time
+}
+
+// StepError 启动步骤失败
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("%s：%v", stepNames[e.Step], e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+var log *logrus.Logger
+var conf *config.Config
+
+// App 代理的生命周期，托盘与命令行前端共用
+// Start/Stop/Restart 可以并发调用，同一时间只有一个在执行，其余等待
+type App struct {
+	mu sync.Mutex // 串行化 Start/Stop/Restart
+
+	stateMu sync.RWMutex
+	state   State
+	err     error
+	since   time.Time // 进入当前状态的时间
+
+	subMu sync.Mutex
+	subs  map[chan Event]struct{}
+
+	// 已完成的步骤，停止或回滚时按相反顺序撤销
+	completed []*step
+
+	// 各步骤的产物
+	issuer    *certController.Issuer
+	pools     []*upstreamController.Pool
+	ruleSet   *rules.RuleSet
+	dnsServer *dnsController.DnsServer
+	serv      *server.Server
+	recorder  *harController.Recorder
+	watchStop chan struct{}
+
+	// 请求统计，重启后保留
+	stats *server.Stats
+}
+
+// recentRequests 保留的最近请求数
+const recentRequests = 200
+
+func New() *App {
+	log = logger.GetLogger()
+	conf = config.GetConfig()
+	return &App{
+		state: StateStopped,
+		since: time.Now(),
+		subs:  make(map[chan Event]struct{}),
+		stats: server.NewStats(recentRequests),
+	}
+}
+
+// State 当前状态，失败时返回失败原因
+func (a *App) State() (State, error) {
+	a.stateMu.RLock()
+	defer a.stateMu.RUnlock()
+	return a.state, a.err
+}
+
+// Since 进入当前状态的时间，运行中时即为启动完成的时间
+func (a *App) Since() time.Time {
+	a.stateMu.RLock()
+	defer a.stateMu.RUnlock()
+	return a.since
+}
+
+// Stats 请求统计
+func (a *App) Stats() *server.Stats {
+	return a.stats
+}
+
+// Upstreams 运行中的上游池，未运行时为空
+func (a *App) Upstreams() []*upstreamController.Pool {
+	a.stateMu.RLock()
+	defer a.stateMu.RUnlock()
+	return a.pools
+}
+
+// Subscribe 订阅事件，返回的函数用于取消订阅
+// 事件不会阻塞生命周期，订阅者处理不及时时丢弃多出的事件
+func (a *App) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+	a.subMu.Lock()
+	a.subs[ch] = struct{}{}
+	a.subMu.Unlock()
+	return ch, func() {
+		a.subMu.Lock()
+		defer a.subMu.Unlock()
+		if _, ok := a.subs[ch]; ok {
+			delete(a.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (a *App) emit(e Event) {
+	e.Time = time.Now()
+	a.subMu.Lock()
+	defer a.subMu.Unlock()
+	for ch := range a.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (a *App) setState(state State, err error) {
+	a.stateMu.Lock()
+	a.state, a.err, a.since = state, err, time.Now()
+	a.stateMu.Unlock()
+	if err != nil {
+		log.Errorf("状态：%s，%v", state, err)
+	} else {
+		log.Infof("状态：%s", state)
+	}
+	a.emit(Event{Kind: EventState, State: state, Err: err})
+}
+
+// Start 按步骤启动，某一步失败时回滚已完成的步骤并返回 *StepError；已在运行时直接返回
+func (a *App) Start() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.start()
+}
+
+// Stop 按相反顺序撤销已完成的步骤；未运行时直接返回
+func (a *App) Stop() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stop()
+}
+
+// Restart 停止后重新启动
+func (a *App) Restart() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.stop(); err != nil {
+		return err
+	}
+	return a.start()
+}
+
+// Reload 重新读取配置文件，运行中时重启以应用新配置；读取失败时保留当前配置并继续运行
+func (a *App) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	running := false
+	if state, _ := a.State(); state == StateRunning {
+		running = true
+		if err := a.stop(); err != nil {
+			return err
+		}
+	}
+	err := config.Reload()
+	if running {
+		if startErr := a.start(); startErr != nil {
+			return errors.Join(err, startErr)
+		}
+	}
+	return err
+}
+
+func (a *App) start() error {
+	if state, _ := a.State(); state == StateRunning {
+		return nil
+	}
+	a.setState(StateStarting, nil)
+	for _, s := range steps {
+		log.Infof("启动步骤：%s", stepNames[s.name])
+		if err := s.start(a); err != nil {
+			stepErr := &StepError{Step: s.name, Err: err}
+			a.rollback()
+			a.setState(StateFailed, stepErr)
+			return stepErr
+		}
+		a.completed = append(a.completed, s)
+	}
+
+	// 代理服务器意外退出时回滚并进入失败状态
+	a.watchStop = make(chan struct{})
+	go a.watch(a.serv, a.watchStop)
+
+	a.setState(StateRunning, nil)
+	return nil
+}
+
+func (a *App) stop() error {
+	if state, _ := a.State(); state != StateRunning {
+		return nil
+	}
+	a.setState(StateStopping, nil)
+	err := a.rollback()
+	a.setState(StateStopped, nil)
+	return err
+}
+
+// rollback 按相反顺序撤销已完成的步骤，撤销失败只记录，不影响其余步骤
+func (a *App) rollback() error {
+	if a.watchStop != nil {
+		close(a.watchStop)
+		a.watchStop = nil
+	}
+	var errs []error
+	for i := len(a.completed) - 1; i >= 0; i-- {
+		s := a.completed[i]
+		if s.rollback == nil {
+			continue
+		}
+		if err := s.rollback(a); err != nil {
+			log.Warnf("撤销步骤 %s 失败：%v", stepNames[s.name], err)
+			errs = append(errs, &StepError{Step: s.name, Err: err})
+		}
+	}
+	a.completed = nil
+	return errors.Join(errs...)
+}
+
+// watch 等待代理服务器退出
+func (a *App) watch(serv *server.Server, stop chan struct{}) {
+	var err error
+	select {
+	case err = <-serv.Done():
+	case <-stop:
+		return
+	}
+	if err == nil {
+		err = errors.New("代理服务器已退出")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// 等待锁期间可能已经停止或重启
+	if a.serv != serv {
+		return
+	}
+	if state, _ := a.State(); state != StateRunning {
+		return
+	}
+	a.rollback()
+	a.setState(StateFailed, err)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"idv-login-go/certController"
+	"idv-login-go/config"
+	"idv-login-go/constants"
+	"idv-login-go/logger"
+	"time"
+)
+
+// CertHosts 证书需要覆盖的主机名
+func CertHosts() []string {
+	conf = config.GetConfig()
+	return append(conf.Hosts(), conf.Strings("sniAllowList")...)
+}
+
+// CertFiles 证书相关文件
+func CertFiles() certController.Files {
+	return certController.Files{
+		CA:     constants.CaPath,
+		CAKey:  constants.CaKeyPath,
+		Cert:   constants.CertPath,
+		Key:    constants.KeyPath,
+		Record: constants.TrustRecordPath,
+	}
+}
+
+// TrustOptions 信任库选项
+func TrustOptions() certController.TrustOptions {
+	conf = config.GetConfig()
+	return certController.TrustOptions{
+		Dir:          conf.String("trustDir"),
+		NSSDatabases: conf.Strings("nssDatabases"),
+	}
+}
+
+// NewCertController 按配置创建证书管理
+func NewCertController() (*certController.CertController, error) {
+	conf = config.GetConfig()
+	stores, err := certController.NewTrustStores(conf.Strings("trustStores"), TrustOptions())
+	if err != nil {
+		return nil, fmt.Errorf("初始化信任库失败：%w", err)
+	}
+	return certController.New(certController.Options{
+		KeyType:      conf.String("certKeyType"),
+		Hosts:        CertHosts(),
+		LeafValidity: time.Duration(conf.Int("certLeafDays")) * 24 * time.Hour,
+		TrustStores:  stores,
+	}), nil
+}
+
+// PrepareCert 检查证书，有问题时重新生成、续期或重新导入CA
+func PrepareCert() (*certController.CertController, error) {
+	log = logger.GetLogger()
+	certM, err := NewCertController()
+	if err != nil {
+		return nil, err
+	}
+
+	// 启动前检查证书，过期、密钥不匹配、主机名不符或不再受信任时自动修复
+	files := CertFiles()
+	health := certM.Check(files)
+	if health.OK() {
+		log.Info("证书检查通过")
+		return certM, nil
+	}
+	for _, problem := range health.Problems {
+		log.Warnf("证书检查：%s", problem)
+	}
+	if err := certM.Repair(files, health); err != nil {
+		return nil, fmt.Errorf("修复证书失败：%w", err)
+	}
+
+	// 修复后重新检查，信任库导入失败不影响启动
+	health = certM.Check(files)
+	for _, problem := range health.Problems {
+		log.Warnf("证书修复后仍存在问题：%s", problem)
+	}
+	if health.NeedsCA() || health.NeedsLeaf() {
+		return nil, errors.New("证书修复失败，请尝试卸载后重新运行")
+	}
+	return certM, nil
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"idv-login-go/certController"
+	"idv-login-go/dnsController"
+	"idv-login-go/harController"
+	"idv-login-go/hostsController"
+	"idv-login-go/rules"
+	"idv-login-go/server"
+	"idv-login-go/upstreamController"
+	"strings"
+	"time"
+)
+
+// 重定向方式
+const (
+	RedirectHosts = "hosts" // 修改hosts文件
+	RedirectDNS   = "dns"   // 本地DNS服务器
+)
+
+// 启动步骤
+const (
+	StepHosts    = "hosts"
+	StepCert     = "cert"
+	StepUpstream = "upstream"
+	StepRules    = "rules"
+	StepDNS      = "dns"
+	StepServer   = "server"
+)
+
+var stepNames = map[string]string{
+	StepHosts:    "写入hosts",
+	StepCert:     "准备证书",
+	StepUpstream: "选择上游",
+	StepRules:    "加载改写规则",
+	StepDNS:      "启动DNS服务器",
+	StepServer:   "启动代理服务器",
+}
+
+// step 启动步骤，rollback 撤销该步骤，为空时无需撤销
+type step struct {
+	name     string
+	start    func(a *App) error
+	rollback func(a *App) error
+}
+
+// steps 按顺序执行，启动失败或停止时按相反顺序撤销，撤销后不保留该步骤设置的状态
+var steps = []*step{
+	{name: StepHosts, start: (*App).addHosts, rollback: (*App).removeHosts},
+	{name: StepCert, start: (*App).prepareCert, rollback: (*App).releaseCert},
+	{name: StepUpstream, start: (*App).startUpstreams, rollback: (*App).stopUpstreams},
+	{name: StepRules, start: (*App).loadRules, rollback: (*App).unloadRules},
+	{name: StepDNS, start: (*App).startDNS, rollback: (*App).stopDNS},
+	{name: StepServer, start: (*App).startServer, rollback: (*App).stopServer},
+}
+
+func dnsMode() bool {
+	return conf.String("redirectMode") == RedirectDNS
+}
+
+// offlineMode 离线回放且未匹配的请求不会发往上游
+func offlineMode() bool {
+	return len(conf.Strings("replayFiles")) > 0 && conf.String("replayMatch") != harController.MatchPassthrough
+}
+
+func (a *App) addHosts() error {
+	if dnsMode() {
+		return nil
+	}
+	hostC := hostsController.New()
+	if !hostC.IsWritable() {
+		return errors.New("hosts 文件不可写，请关闭杀毒软件、使用管理员权限运行本程序或将 redirectMode 设置为 dns")
+	}
+	if !hostC.Exist() {
+		log.Info("hosts中不存在，添加")
+		if !hostC.Add() {
+			return errors.New("写入hosts失败")
+		}
+	}
+	log.Info("hosts准备完成")
+	return nil
+}
+
+func (a *App) removeHosts() error {
+	if dnsMode() {
+		return nil
+	}
+	hostC := hostsController.New()
+	if !hostC.Managed() {
+		return nil
+	}
+	if !hostC.Remove() {
+		return errors.New("hosts 文件不可写，请关闭杀毒软件或使用管理员权限运行本程序")
+	}
+	log.Info("hosts移除完成")
+	return nil
+}
+
+func (a *App) prepareCert() error {
+	certM, err := PrepareCert()
+	if err != nil {
+		return err
+	}
+	a.issuer = certController.NewIssuer(certM, CertHosts())
+	log.Infof("证书准备完成")
+	return nil
+}
+
+func (a *App) releaseCert() error {
+	a.issuer = nil
+	return nil
+}
+
+// startUpstreams 每个主机名各自解析并选择上游IP，然后在后台检查上游状态
+func (a *App) startUpstreams() error {
+	var pools []*upstreamController.Pool
+	for _, host := range conf.Hosts() {
+		pool, err := newPool(host)
+		if err != nil {
+			return fmt.Errorf("初始化 %s 的上游失败：%w", host, err)
+		}
+		pools = append(pools, pool)
+	}
+
+	for _, pool := range pools {
+		pool.SetOnChange(func([]upstreamController.Candidate) {
+			a.emit(Event{Kind: EventUpstream, State: StateRunning})
+		})
+		pool.Start()
+	}
+	a.stateMu.Lock()
+	a.pools = pools
+	a.stateMu.Unlock()
+	a.emit(Event{Kind: EventUpstream, State: StateStarting})
+	return nil
+}
+
+func (a *App) stopUpstreams() error {
+	a.stateMu.Lock()
+	pools := a.pools
+	a.pools = nil
+	a.stateMu.Unlock()
+	for _, pool := range pools {
+		pool.Stop()
+	}
+	a.emit(Event{Kind: EventUpstream, State: StateStopping})
+	return nil
+}
+
+func (a *App) loadRules() error {
+	ruleSet, err := rules.Load()
+	if err != nil {
+		return err
+	}
+	a.ruleSet = ruleSet
+	log.Infof("改写规则加载完成，共 %d 条", ruleSet.Len())
+	return nil
+}
+
+func (a *App) unloadRules() error {
+	a.ruleSet = nil
+	return nil
+}
+
+func (a *App) startDNS() error {
+	if !dnsMode() {
+		return nil
+	}
+	dnsServer := dnsController.NewDnsServer(conf.String("dnsListen"), CertHosts(), conf.Strings("dnsUpstream"))
+	if err := dnsServer.Start(); err != nil {
+		return err
+	}
+	a.dnsServer = dnsServer
+	log.Info("DNS服务器准备完成")
+	return nil
+}
+
+func (a *App) stopDNS() error {
+	if a.dnsServer != nil {
+		a.dnsServer.Stop()
+		a.dnsServer = nil
+	}
+	return nil
+}
+
+func (a *App) startServer() error {
+	serv := server.NewServer(a.Upstreams(), a.ruleSet, a.issuer)
+	serv.SetStats(a.stats)
+	serv.SetListen(conf.Strings("listen"))
+	if a.dnsServer != nil {
+		serv.SetResolver(a.dnsServer.Resolver())
+	}
+	if files := conf.Strings("replayFiles"); len(files) > 0 {
+		replay, err := harController.NewReplay(files, harController.ReplayOptions{
+			Match:        conf.String("replayMatch"),
+			IgnoreParams: conf.Strings("replayIgnoreParams"),
+		})
+		if err != nil {
+			return fmt.Errorf("开启离线回放失败：%w", err)
+		}
+		serv.SetReplay(replay)
+	}
+	var recorder *harController.Recorder
+	if conf.Bool("harCapture") {
+		var err error
+		recorder, err = harController.NewRecorder(harController.Options{
+			Dir:       conf.String("harDir"),
+			MaxBytes:  conf.Int64("harMaxMB") << 20,
+			FileBytes: conf.Int64("harFileMB") << 20,
+			BodyLimit: conf.Int("harBodyKB") << 10,
+		})
+		if err != nil {
+			return fmt.Errorf("开启HAR记录失败：%w", err)
+		}
+		serv.SetRecorder(recorder)
+	}
+	if err := serv.Start(); err != nil {
+		if recorder != nil {
+			recorder.Close()
+		}
+		return err
+	}
+	a.serv = serv
+	a.recorder = recorder
+	return nil
+}
+
+func (a *App) stopServer() error {
+	serv, recorder := a.serv, a.recorder
+	a.serv, a.recorder = nil, nil
+	if serv == nil {
+		return nil
+	}
+	err := serv.Shutdown()
+	// 等待进行中的请求写完记录后再关闭
+	if recorder != nil {
+		recorder.Close()
+	}
+	return err
+}
+
+// upstreamPins 主机名对应的SPKI固定列表
+// upstreamPins 为表时按主机名查找；为列表时是旧版本的写法，只用于 host
+func upstreamPins(host string) ([]string, error) {
+	switch v := conf.Koanf().Get("upstreamPins").(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		for name, pins := range v {
+			if strings.EqualFold(strings.TrimSuffix(name, "."), host) {
+				return toStrings(pins)
+			}
+		}
+		return nil, nil
+	default:
+		if host != conf.Hosts()[0] {
+			return nil, nil
+		}
+		return toStrings(v)
+	}
+}
+
+// toStrings 将配置中的列表转为字符串列表
+func toStrings(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("upstreamPins 中的 %v 不是字符串", item)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("upstreamPins 的格式无效：%v", v)
+}
+
+// newPool 解析主机名并按TLS握手延迟选择上游IP，离线回放时不访问网络
+func newPool(host string) (*upstreamController.Pool, error) {
+	if offlineMode() {
+		log.Infof("%s 使用离线回放，不检查上游", host)
+		return upstreamController.NewPool(host, []string{conf.String("defaultIP")}, upstreamController.Options{})
+	}
+	var ips []string
+	dnsC, err := dnsController.NewDnsController(host)
+	if err == nil {
+		var result *dnsController.Result
+		if result, err = dnsC.Resolve(); err == nil {
+			ips = result.IPs()
+		}
+	}
+	if err != nil {
+		// 只有 host 有默认IP
+		if host != conf.Hosts()[0] {
+			return nil, fmt.Errorf("DNS解析失败：%w", err)
+		}
+		log.Errorf("DNS解析失败：%v\n将使用默认IP", err)
+		ips = []string{conf.String("defaultIP")}
+	}
+	log.Infof("%s DNS解析结果：%v", host, ips)
+
+	pins, err := upstreamPins(host)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := upstreamController.NewPool(host, ips, upstreamController.Options{
+		ProbeCount:       conf.Int("upstreamProbeCount"),
+		ProbeTimeout:     time.Duration(conf.Int("upstreamProbeTimeout")) * time.Second,
+		HealthInterval:   time.Duration(conf.Int("upstreamHealthInterval")) * time.Second,
+		BreakerThreshold: conf.Int("upstreamBreakerThreshold"),
+		BreakerCooldown:  time.Duration(conf.Int("upstreamBreakerCooldown")) * time.Second,
+		Retries:          conf.Int("upstreamRetries"),
+		Pins:             pins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pool.Rank(context.Background())
+	log.Infof("%s 使用上游IP：%s", host, pool.Best())
+	return pool, nil
+}
@@ -0,0 +1,305 @@
+package certController
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Files 证书相关文件的路径
+type Files struct {
+	CA     string // CA证书
+	CAKey  string // CA私钥
+	Cert   string // 网站证书
+	Key    string // 网站私钥
+	Record string // 安装记录
+}
+
+// ProblemKind 证书问题类型
+type ProblemKind string
+
+const (
+	ProblemCAMissing    ProblemKind = "ca_missing"    // CA证书或私钥不存在、无法解析
+	ProblemCAExpired    ProblemKind = "ca_expired"    // CA证书已过期或即将过期
+	ProblemCAKey        ProblemKind = "ca_key"        // CA私钥与CA证书不匹配
+	ProblemCAConstraint ProblemKind = "ca_constraint" // CA的名称约束不包含配置的主机名
+	ProblemLeafMissing  ProblemKind = "leaf_missing"  // 网站证书或私钥不存在、无法解析
+	ProblemLeafExpired  ProblemKind = "leaf_expired"  // 网站证书已过期或需要续期
+	ProblemLeafKey      ProblemKind = "leaf_key"      // 网站私钥与网站证书不匹配
+	ProblemLeafChain    ProblemKind = "leaf_chain"    // 网站证书不是由当前CA签发
+	ProblemLeafSAN      ProblemKind = "leaf_san"      // 网站证书不包含配置的主机名
+	ProblemUntrusted    ProblemKind = "untrusted"     // 信任库中没有CA证书
+)
+
+// Problem 证书检查发现的问题
+type Problem struct {
+	Kind   ProblemKind
+	Detail string
+	store  TrustStore
+}
+
+func (p Problem) String() string {
+	return p.Detail
+}
+
+// Health 证书检查结果
+type Health struct {
+	Problems []Problem
+}
+
+// OK 是否没有任何问题
+func (h *Health) OK() bool {
+	return len(h.Problems) == 0
+}
+
+// NeedsCA 是否需要重新生成CA，重新生成CA时网站证书也会一并重新签发并重新导入
+func (h *Health) NeedsCA() bool {
+	return h.has(ProblemCAMissing, ProblemCAExpired, ProblemCAKey, ProblemCAConstraint)
+}
+
+// NeedsLeaf 是否需要重新签发网站证书
+func (h *Health) NeedsLeaf() bool {
+	return h.has(ProblemLeafMissing, ProblemLeafExpired, ProblemLeafKey, ProblemLeafChain, ProblemLeafSAN)
+}
+
+// NeedsImport 是否需要重新导入CA证书
+func (h *Health) NeedsImport() bool {
+	return h.has(ProblemUntrusted)
+}
+
+func (h *Health) has(kinds ...ProblemKind) bool {
+	for _, p := range h.Problems {
+		if slices.Contains(kinds, p.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Health) add(kind ProblemKind, format string, args ...interface{}) {
+	h.Problems = append(h.Problems, Problem{Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Check 从文件加载证书与私钥并检查证书链、密钥配对、主机名覆盖、剩余有效期以及信任库
+// 能加载的部分会保留在 CertController 中，供 Repair 使用
+func (cm *CertController) Check(files Files) *Health {
+	h := &Health{}
+	var err error
+
+	cm.CaCert, cm.CaKey, cm.WebCert, cm.LeafKey = nil, nil, nil, nil
+	if cm.CaCert, err = LoadCert(files.CA); err != nil {
+		h.add(ProblemCAMissing, "CA证书不可用：%v", err)
+	}
+	if cm.CaKey, err = loadKey(files.CAKey); err != nil {
+		h.add(ProblemCAMissing, "CA私钥不可用：%v", err)
+	}
+	if cm.WebCert, err = LoadCert(files.Cert); err != nil {
+		h.add(ProblemLeafMissing, "网站证书不可用：%v", err)
+	}
+	if cm.LeafKey, err = loadKey(files.Key); err != nil {
+		h.add(ProblemLeafMissing, "网站私钥不可用：%v", err)
+	}
+
+	now := time.Now()
+	if ca := cm.CaCert; ca != nil {
+		// CA剩余有效期不足一个网站证书周期时重新生成
+		if now.Add(cm.leafTime).After(ca.NotAfter) {
+			h.add(ProblemCAExpired, "CA证书将于 %s 过期", ca.NotAfter.Format(time.DateTime))
+		}
+		if !ca.IsCA {
+			h.add(ProblemCAKey, "%s 不是CA证书", files.CA)
+		}
+		if cm.CaKey != nil && !publicKeyEqual(cm.CaKey.Public(), ca.PublicKey) {
+			h.add(ProblemCAKey, "CA私钥与CA证书不匹配")
+		}
+		for _, host := range cm.hosts {
+			if !Permitted(ca, host) {
+				h.add(ProblemCAConstraint, "CA的名称约束不允许签发 %s", host)
+			}
+		}
+	}
+
+	if leaf := cm.WebCert; leaf != nil {
+		if now.Before(leaf.NotBefore) {
+			h.add(ProblemLeafExpired, "网站证书尚未生效，生效时间 %s", leaf.NotBefore.Format(time.DateTime))
+		} else if cm.NeedsRenewal(leaf) {
+			h.add(ProblemLeafExpired, "网站证书将于 %s 过期", leaf.NotAfter.Format(time.DateTime))
+		}
+		if cm.LeafKey != nil && !publicKeyEqual(cm.LeafKey.Public(), leaf.PublicKey) {
+			h.add(ProblemLeafKey, "网站私钥与网站证书不匹配")
+		}
+		if cm.CaCert != nil {
+			if err := leaf.CheckSignatureFrom(cm.CaCert); err != nil {
+				h.add(ProblemLeafChain, "网站证书不是由当前CA签发：%v", err)
+			}
+		}
+		for _, host := range cm.hosts {
+			if !covers(leaf.DNSNames, host) {
+				h.add(ProblemLeafSAN, "网站证书不包含主机名 %s", host)
+			}
+		}
+	}
+
+	// CA需要重新生成时不再检查信任库
+	if cm.CaCert != nil && !h.NeedsCA() {
+		for _, store := range cm.stores {
+			ok, err := store.Contains(cm.CaCert)
+			if err != nil {
+				log.Warnf("检查信任库 %s 失败：%v", store.Name(), err)
+				continue
+			}
+			if !ok {
+				h.Problems = append(h.Problems, Problem{
+					Kind:   ProblemUntrusted,
+					Detail: fmt.Sprintf("信任库 %s 中没有CA证书", store.Name()),
+					store:  store,
+				})
+			}
+		}
+	}
+	return h
+}
+
+// Repair 按检查结果重新生成CA、重新签发网站证书或重新导入CA证书，无法修复时返回原因
+func (cm *CertController) Repair(files Files, h *Health) error {
+	if h.NeedsCA() {
+		return cm.regenerate(files)
+	}
+	if h.NeedsLeaf() {
+		log.Info("重新签发网站证书")
+		if err := cm.GenerateCert(cm.hosts); err != nil {
+			return fmt.Errorf("签发网站证书失败：%w", err)
+		}
+		if err := cm.export(files.Cert, files.Key, cm.WebCert, cm.LeafKey); err != nil {
+			return err
+		}
+	}
+	if h.NeedsImport() {
+		var stores []TrustStore
+		for _, p := range h.Problems {
+			if p.Kind == ProblemUntrusted {
+				stores = append(stores, p.store)
+			}
+		}
+		installed, err := cm.importTo(files.CA, stores)
+		if err != nil {
+			return fmt.Errorf("重新导入CA证书失败：%w", err)
+		}
+		if err := AddInstallRecord(files.Record, cm.CaCert, installed); err != nil {
+			log.Errorf("保存安装记录失败：%v", err)
+		}
+	}
+	return nil
+}
+
+// regenerate 重新生成CA与网站证书并导入信任库，旧CA会先从信任库中移除
+func (cm *CertController) regenerate(files Files) error {
+	if old := cm.CaCert; old != nil {
+		for _, store := range cm.stores {
+			if ok, _ := store.Contains(old); !ok {
+				continue
+			}
+			if err := store.Uninstall(old); err != nil {
+				log.Warnf("从 %s 移除旧CA证书失败：%v", store.Name(), err)
+			} else {
+				log.Infof("已从 %s 移除旧CA证书", store.Name())
+			}
+		}
+	}
+
+	log.Info("重新生成CA证书与网站证书")
+	if err := cm.Generate(files, true); err != nil {
+		return err
+	}
+
+	installed, err := cm.ImportToRoot(files.CA)
+	if err != nil {
+		// 删除证书文件，下次启动时重新生成
+		for _, fn := range []string{files.CA, files.CAKey, files.Cert, files.Key} {
+			os.Remove(fn)
+		}
+		return fmt.Errorf("导入CA证书失败：%w", err)
+	}
+	// 记录导入的信任库，卸载时使用
+	if err := AddInstallRecord(files.Record, cm.CaCert, installed); err != nil {
+		log.Errorf("保存安装记录失败：%v", err)
+	}
+	return nil
+}
+
+// Generate 生成证书文件但不导入信任库，newCA 为 false 时沿用已加载的CA只重新签发网站证书
+func (cm *CertController) Generate(files Files, newCA bool) error {
+	if newCA || cm.CaCert == nil || cm.CaKey == nil {
+		// CA与网站证书使用不同的私钥
+		if err := cm.GenerateCA(); err != nil {
+			return fmt.Errorf("生成CA证书失败：%w", err)
+		}
+		if err := cm.export(files.CA, files.CAKey, cm.CaCert, cm.CaKey); err != nil {
+			return err
+		}
+	}
+	if err := cm.GenerateCert(cm.hosts); err != nil {
+		return fmt.Errorf("生成网站证书失败：%w", err)
+	}
+	return cm.export(files.Cert, files.Key, cm.WebCert, cm.LeafKey)
+}
+
+// export 导出证书和私钥
+func (cm *CertController) export(certPath string, keyPath string, cert *x509.Certificate, key crypto.Signer) error {
+	if _, err := cm.ExportCert(certPath, cert); err != nil {
+		return fmt.Errorf("保存证书 %s 失败：%w", certPath, err)
+	}
+	if _, err := cm.ExportKey(keyPath, key); err != nil {
+		return fmt.Errorf("保存私钥 %s 失败：%w", keyPath, err)
+	}
+	return nil
+}
+
+// importTo 将CA证书导入指定的信任库
+func (cm *CertController) importTo(fn string, stores []TrustStore) ([]string, error) {
+	var installed []string
+	var errs []error
+	for _, store := range stores {
+		if err := store.Install(fn, cm.CaCert); err != nil {
+			errs = append(errs, fmt.Errorf("%s：%w", store.Name(), err))
+			continue
+		}
+		log.Infof("已重新导入CA证书到 %s", store.Name())
+		installed = append(installed, store.Name())
+	}
+	if len(installed) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		log.Warnf("部分信任库导入失败：%v", errors.Join(errs...))
+	}
+	return installed, nil
+}
+
+// publicKeyEqual 标准库的公钥类型都实现了 Equal
+func publicKeyEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// covers 证书的DNSNames是否覆盖主机名
+func covers(names []string, host string) bool {
+	host = normalizeHost(host)
+	for _, name := range names {
+		name = normalizeHost(name)
+		if name == host {
+			return true
+		}
+		// 通配证书只覆盖一级子域名
+		if suffix, ok := strings.CutPrefix(name, "*"); ok && strings.HasSuffix(host, suffix) &&
+			!strings.Contains(strings.TrimSuffix(host, suffix), ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package certController
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Issuer 根据TLS握手中的SNI按需签发并缓存证书
+type Issuer struct {
+	cm        *CertController
+	allowList []string
+
+	mu    sync.Mutex
+	cache map[string]*tls.Certificate
+}
+
+// NewIssuer allowList 为允许签发的主机名，支持 *.example.com 形式的通配
+// 已持久化的网站证书会预先放入缓存，覆盖不到的主机名再用网站私钥按需签发
+func NewIssuer(cm *CertController, allowList []string) *Issuer {
+	list := make([]string, 0, len(allowList))
+	for _, name := range allowList {
+		if name = normalizeHost(name); name != "" {
+			list = append(list, name)
+		}
+	}
+	i := &Issuer{
+		cm:        cm,
+		allowList: list,
+		cache:     make(map[string]*tls.Certificate),
+	}
+	if cm.WebCert != nil {
+		cert := &tls.Certificate{
+			Certificate: [][]byte{cm.WebCert.Raw, cm.CaCert.Raw},
+			PrivateKey:  cm.LeafKey,
+			Leaf:        cm.WebCert,
+		}
+		for _, name := range cm.WebCert.DNSNames {
+			i.cache[normalizeHost(name)] = cert
+		}
+	}
+	return i
+}
+
+// GetCertificate 用于 tls.Config.GetCertificate
+func (i *Issuer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeHost(hello.ServerName)
+	if name == "" {
+		// 客户端未发送SNI时使用第一个允许的主机名
+		if len(i.allowList) == 0 || strings.HasPrefix(i.allowList[0], "*.") {
+			return nil, fmt.Errorf("客户端未提供SNI")
+		}
+		name = i.allowList[0]
+	}
+	if !i.Allowed(name) {
+		return nil, fmt.Errorf("主机名 %s 不在允许列表中", name)
+	}
+	if !Permitted(i.cm.CaCert, name) {
+		return nil, fmt.Errorf("主机名 %s 超出CA的名称约束，需要重新生成CA", name)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// 即将过期的证书自动续期
+	if cert, ok := i.cache[name]; ok && !i.cm.NeedsRenewal(cert.Leaf) {
+		return cert, nil
+	}
+
+	cert, err := i.issue(name)
+	if err != nil {
+		return nil, fmt.Errorf("为 %s 签发证书失败：%w", name, err)
+	}
+	i.cache[name] = cert
+	return cert, nil
+}
+
+// Allowed 主机名是否在允许列表中
+func (i *Issuer) Allowed(name string) bool {
+	name = normalizeHost(name)
+	for _, pattern := range i.allowList {
+		if pattern == name {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasSuffix(name, suffix) &&
+			!strings.Contains(strings.TrimSuffix(name, suffix), ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// issue 签发证书，证书链包含CA证书
+func (i *Issuer) issue(name string) (*tls.Certificate, error) {
+	leaf, err := i.cm.signCert([]string{name}, i.cm.LeafKey)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("已为 %s 签发证书，有效期至 %s", name, leaf.NotAfter.Format(time.DateTime))
+	return &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw, i.cm.CaCert.Raw},
+		PrivateKey:  i.cm.LeafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+func normalizeHost(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// Permitted CA的名称约束是否允许签发该主机名
+func Permitted(ca *x509.Certificate, name string) bool {
+	if len(ca.PermittedDNSDomains) == 0 {
+		return true
+	}
+	name = normalizeHost(name)
+	for _, domain := range ca.PermittedDNSDomains {
+		domain = normalizeHost(domain)
+		if strings.HasPrefix(domain, ".") {
+			if strings.HasSuffix(name, domain) {
+				return true
+			}
+			continue
+		}
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,47 +1,74 @@
 package certController
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"idv-login-go/logger"
 	"math/big"
 	"os"
-	"os/exec"
+	"strings"
 	"time"
 )
 
+// Options 证书生成选项
+type Options struct {
+	KeyType      string        // 密钥算法：rsa/ecdsa/ed25519
+	Hosts        []string      // CA允许签发的主机名，写入名称约束，支持 *.example.com
+	LeafValidity time.Duration // 网站证书有效期
+	TrustStores  []TrustStore  // 导入CA证书的信任库
+}
+
+var log *logrus.Logger
+
 type CertController struct {
-	time       time.Duration
-	PrivateKey *rsa.PrivateKey
-	CaCert     *x509.Certificate
-	WebCert    *x509.Certificate
+	time     time.Duration
+	leafTime time.Duration
+	keyType  string
+	hosts    []string
+	stores   []TrustStore
+	CaKey    crypto.Signer
+	CaCert   *x509.Certificate
+	LeafKey  crypto.Signer
+	WebCert  *x509.Certificate
 }
 
-func New() *CertController {
-	cm := &CertController{time: 3650 * 24 * time.Hour}
-	cm.generatePrivateKey(2048)
-	return cm
+func New(opts Options) *CertController {
+	log = logger.GetLogger()
+	if opts.LeafValidity <= 0 {
+		opts.LeafValidity = 30 * 24 * time.Hour
+	}
+	return &CertController{
+		time:     3650 * 24 * time.Hour,
+		leafTime: opts.LeafValidity,
+		keyType:  opts.KeyType,
+		hosts:    opts.Hosts,
+		stores:   opts.TrustStores,
+	}
 }
 
-func (cm *CertController) generatePrivateKey(bits int) error {
-	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+// GenerateCA 生成CA私钥与CA证书，CA只能签发 Options.Hosts 中的主机名
+func (cm *CertController) GenerateCA() error {
+	key, err := generateKey(cm.keyType)
 	if err != nil {
 		return err
 	}
-	cm.PrivateKey = privateKey
-	return nil
-}
 
-func (cm *CertController) GenerateCA() error {
 	// 证书时间
 	notBefore := time.Now()
 	notAfter := notBefore.Add(cm.time)
 
 	// 生成证书序列号
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+	skid, err := subjectKeyId(key.Public())
 	if err != nil {
 		return err
 	}
@@ -58,12 +85,19 @@ func (cm *CertController) GenerateCA() error {
 		},
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
+		// 只允许签发网站证书，不允许再签发下级CA
+		MaxPathLen:     0,
+		MaxPathLenZero: true,
+		SubjectKeyId:   skid,
+		// 名称约束，即使CA私钥泄露也只能用于被拦截的主机名
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         permittedDomains(cm.hosts),
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &cm.PrivateKey.PublicKey, cm.PrivateKey)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
 	if err != nil {
 		return err
 	}
@@ -72,20 +106,50 @@ func (cm *CertController) GenerateCA() error {
 	if err != nil {
 		return err
 	}
+	cm.CaKey = key
 	cm.CaCert = caCert
 
 	return nil
 }
 
+// GenerateCert 生成网站私钥并签发包含hostnames的网站证书
 func (cm *CertController) GenerateCert(hostnames []string) error {
-	notBefore := time.Now()
-	notAfter := notBefore.Add(cm.time)
-
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	key, err := generateKey(cm.keyType)
 	if err != nil {
 		return err
 	}
+	webCert, err := cm.signCert(hostnames, key)
+	if err != nil {
+		return err
+	}
+	cm.LeafKey = key
+	cm.WebCert = webCert
+
+	return nil
+}
+
+// NeedsRenewal 网站证书剩余有效期不足三分之一时需要续期
+func (cm *CertController) NeedsRenewal(cert *x509.Certificate) bool {
+	return time.Now().Add(cm.leafTime / 3).After(cert.NotAfter)
+}
+
+// signCert 使用CA签发包含hostnames的网站证书
+func (cm *CertController) signCert(hostnames []string, key crypto.Signer) (*x509.Certificate, error) {
+	// 提前一小时生效，避免本机时间略有偏差时证书尚未生效
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(cm.leafTime)
+	if notAfter.After(cm.CaCert.NotAfter) {
+		notAfter = cm.CaCert.NotAfter
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	skid, err := subjectKeyId(key.Public())
+	if err != nil {
+		return nil, err
+	}
 	// 生成证书
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
@@ -95,44 +159,55 @@ func (cm *CertController) GenerateCert(hostnames []string) error {
 			Locality:           []string{""},
 			Organization:       []string{"Login Helper GO"},
 			OrganizationalUnit: []string{"Login Helper GO"},
+			CommonName:         hostnames[0],
 		},
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              keyUsage(key),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
+		SubjectKeyId:          skid,
+		AuthorityKeyId:        cm.CaCert.SubjectKeyId,
 		DNSNames:              hostnames,
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, cm.CaCert, &cm.PrivateKey.PublicKey, cm.PrivateKey)
-	if err != nil {
-		return err
-	}
-
-	caCert, err := x509.ParseCertificate(derBytes)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, cm.CaCert, key.Public(), cm.CaKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	cm.WebCert = caCert
 
-	return nil
+	return x509.ParseCertificate(derBytes)
 }
 
-func (cm *CertController) ImportToRoot(fn string) (bool, error) {
-	cmd := exec.Command("certutil", "-addstore", "-f", "Root", fn)
-	err := cmd.Run()
-	if err != nil {
-		return false, err
+// ImportToRoot 将CA证书导入全部信任库，返回导入成功的信任库，全部失败时返回错误
+func (cm *CertController) ImportToRoot(fn string) ([]string, error) {
+	if len(cm.stores) == 0 {
+		return nil, errors.New("没有可用的信任库")
 	}
-	return true, nil
+	var installed []string
+	var errs []error
+	for _, store := range cm.stores {
+		if err := store.Install(fn, cm.CaCert); err != nil {
+			log.Errorf("导入CA证书到 %s 失败：%v", store.Name(), err)
+			errs = append(errs, fmt.Errorf("%s：%w", store.Name(), err))
+			continue
+		}
+		log.Infof("已导入CA证书到 %s", store.Name())
+		installed = append(installed, store.Name())
+	}
+	if len(installed) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return installed, nil
 }
 
-func (cm *CertController) ExportKey(fn string) (bool, error) {
-	pemKey := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(cm.PrivateKey),
+func (cm *CertController) ExportKey(fn string, key crypto.Signer) (bool, error) {
+	pemKey, err := marshalKey(key)
+	if err != nil {
+		return false, err
 	}
 
-	file, err := os.Create(fn)
+	file, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return false, err
 	}
@@ -168,3 +243,69 @@ func (cm *CertController) ExportCert(fn string, cert *x509.Certificate) (bool, e
 	}
 	return true, nil
 }
+
+// permittedDomains 将主机名转换为名称约束，*.example.com 只允许其子域名
+func permittedDomains(hosts []string) []string {
+	domains := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		host = normalizeHost(host)
+		if suffix, ok := strings.CutPrefix(host, "*"); ok {
+			host = suffix
+		}
+		if host != "" {
+			domains = append(domains, host)
+		}
+	}
+	return domains
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, serialNumberLimit)
+}
+
+// LoadCert 读取PEM格式的证书
+func LoadCert(fn string) (*x509.Certificate, error) {
+	block, err := readPem(fn, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书 %s 失败：%w", fn, err)
+	}
+	return cert, nil
+}
+
+func loadKey(fn string) (crypto.Signer, error) {
+	block, err := readPem(fn, "")
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("解析私钥 %s 失败：%w", fn, err)
+	}
+	return key, nil
+}
+
+// readPem 读取文件中第一个指定类型的PEM块，blockType为空时匹配任意私钥
+func readPem(fn string, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			if blockType == "" {
+				return nil, fmt.Errorf("%s 中没有私钥", fn)
+			}
+			return nil, fmt.Errorf("%s 中没有 %s", fn, blockType)
+		}
+		if block.Type == blockType || (blockType == "" && strings.HasSuffix(block.Type, "PRIVATE KEY")) {
+			return block, nil
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package certController
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/goccy/go-json"
+	"idv-login-go/logger"
+	"os"
+	"time"
+)
+
+// InstallRecord CA证书的安装记录，卸载时据此从信任库中移除
+type InstallRecord struct {
+	Cert        []byte    `json:"cert"`        // CA证书DER
+	Stores      []string  `json:"stores"`      // 导入成功的信任库
+	InstalledAt time.Time `json:"installedAt"` // 导入时间
+}
+
+// Certificate 解析记录中的CA证书
+func (r *InstallRecord) Certificate() (*x509.Certificate, error) {
+	return x509.ParseCertificate(r.Cert)
+}
+
+// LoadInstallRecords 读取安装记录，文件不存在时返回空
+func LoadInstallRecords(fn string) ([]InstallRecord, error) {
+	data, err := os.ReadFile(fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []InstallRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// AddInstallRecord 追加一条安装记录，重新生成CA后旧CA的记录仍然保留以便卸载
+func AddInstallRecord(fn string, ca *x509.Certificate, stores []string) error {
+	log = logger.GetLogger()
+	records, err := LoadInstallRecords(fn)
+	if err != nil {
+		log.Warnf("读取安装记录失败，将覆盖：%v", err)
+		records = nil
+	}
+	records = append(records, InstallRecord{
+		Cert:        ca.Raw,
+		Stores:      stores,
+		InstalledAt: time.Now(),
+	})
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, data, 0644)
+}
@@ -0,0 +1,217 @@
+package certController
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"idv-login-go/logger"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// 信任库后端名称
+const (
+	TrustAuto    = "auto"    // 按系统自动选择
+	TrustWindows = "windows" // Windows certutil
+	TrustDebian  = "debian"  // Debian/Ubuntu update-ca-certificates
+	TrustFedora  = "fedora"  // Fedora/Arch update-ca-trust
+	TrustNSS     = "nss"     // NSS数据库，用于Wine与Firefox
+	TrustDir     = "dir"     // 仅复制到目录
+)
+
+// trustName 写入信任库时使用的证书名
+const trustName = "Login Helper GO"
+
+// trustFileName 写入系统证书目录时使用的文件名
+const trustFileName = "idv-login-go"
+
+// TrustStore 信任库后端
+type TrustStore interface {
+	// Name 后端名称，记录在安装记录中
+	Name() string
+	// Install 将CA证书安装到信任库
+	Install(caPath string, ca *x509.Certificate) error
+	// Uninstall 从信任库移除CA证书，ca 为 nil 时移除本程序导入的全部CA证书
+	Uninstall(ca *x509.Certificate) error
+	// Contains 信任库中是否存在该CA证书
+	Contains(ca *x509.Certificate) (bool, error)
+}
+
+// TrustOptions 信任库选项
+type TrustOptions struct {
+	Root         string   // 文件系统根目录，便于在临时目录中测试，默认为 /
+	Dir          string   // dir 后端的目标目录
+	NSSDatabases []string // nss 后端的数据库目录（不含根目录），留空时自动查找
+}
+
+// runCommand 执行外部命令，失败时附带输出
+var runCommand = func(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s：%w %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// commandOutput 执行外部命令并返回标准输出
+var commandOutput = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// lookPath 查找外部命令
+var lookPath = exec.LookPath
+
+// userHomeDir 当前用户的主目录
+var userHomeDir = os.UserHomeDir
+
+// NewTrustStores 按名称创建信任库后端
+func NewTrustStores(names []string, opts TrustOptions) ([]TrustStore, error) {
+	log = logger.GetLogger()
+	if len(names) == 0 {
+		names = []string{TrustAuto}
+	}
+	var stores []TrustStore
+	for _, name := range names {
+		store, err := NewTrustStore(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store...)
+	}
+	if len(stores) == 0 {
+		return nil, errors.New("没有可用的信任库")
+	}
+	return stores, nil
+}
+
+// NewTrustStore 按名称创建信任库后端，auto 与 nss 可能返回多个后端
+func NewTrustStore(name string, opts TrustOptions) ([]TrustStore, error) {
+	switch strings.ToLower(name) {
+	case TrustAuto:
+		return detectTrustStores(opts), nil
+	case TrustWindows:
+		return []TrustStore{&windowsStore{}}, nil
+	case TrustDebian:
+		return []TrustStore{newDebianStore(opts.Root)}, nil
+	case TrustFedora:
+		return []TrustStore{newFedoraStore(opts.Root)}, nil
+	case TrustNSS:
+		dbs := opts.NSSDatabases
+		if len(dbs) == 0 {
+			dbs = findNSSDatabases(opts.Root)
+		}
+		stores := make([]TrustStore, 0, len(dbs))
+		for _, db := range dbs {
+			stores = append(stores, &nssStore{root: opts.Root, dbDir: db})
+		}
+		return stores, nil
+	case TrustDir:
+		if opts.Dir == "" {
+			return nil, errors.New("dir 信任库需要指定目录")
+		}
+		return []TrustStore{&dirStore{dir: opts.Dir}}, nil
+	}
+	return nil, fmt.Errorf("未知的信任库 %q", name)
+}
+
+// FindTrustStore 按Name()找到对应的后端，用于根据安装记录卸载
+func FindTrustStore(name string, opts TrustOptions) (TrustStore, error) {
+	log = logger.GetLogger()
+	if dbDir, ok := strings.CutPrefix(name, TrustNSS+":"); ok {
+		return &nssStore{root: opts.Root, dbDir: dbDir}, nil
+	}
+	if dir, ok := strings.CutPrefix(name, TrustDir+":"); ok {
+		return &dirStore{dir: dir}, nil
+	}
+	stores, err := NewTrustStore(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(stores) != 1 {
+		return nil, fmt.Errorf("信任库 %q 不唯一", name)
+	}
+	return stores[0], nil
+}
+
+// detectTrustStores 按当前系统选择信任库
+func detectTrustStores(opts TrustOptions) []TrustStore {
+	if runtime.GOOS == "windows" {
+		return []TrustStore{&windowsStore{}}
+	}
+
+	var stores []TrustStore
+	if _, err := lookPath("update-ca-certificates"); err == nil {
+		stores = append(stores, newDebianStore(opts.Root))
+	} else if _, err := lookPath("update-ca-trust"); err == nil {
+		stores = append(stores, newFedoraStore(opts.Root))
+	}
+	// NSS数据库需要 certutil（libnss3-tools）
+	if _, err := lookPath("certutil"); err == nil {
+		dbs := opts.NSSDatabases
+		if len(dbs) == 0 {
+			dbs = findNSSDatabases(opts.Root)
+		}
+		for _, db := range dbs {
+			stores = append(stores, &nssStore{root: opts.Root, dbDir: db})
+		}
+	}
+	if opts.Dir != "" {
+		stores = append(stores, &dirStore{dir: opts.Dir})
+	}
+	return stores
+}
+
+// rooted 将绝对路径放到根目录下
+func rooted(root string, path string) string {
+	if root == "" {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// unrooted 去掉路径中的根目录
+func unrooted(root string, path string) string {
+	if root == "" || root == "/" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return string(filepath.Separator) + rel
+}
+
+// copyCert 以PEM格式写入CA证书
+func copyCert(dst string, ca *x509.Certificate) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	return os.WriteFile(dst, data, 0644)
+}
+
+// fileContains 文件中是否包含该证书，文件不存在时返回false
+func fileContains(fn string, ca *x509.Certificate) (bool, error) {
+	data, err := os.ReadFile(fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return false, nil
+		}
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, ca.Raw) {
+			return true, nil
+		}
+	}
+}
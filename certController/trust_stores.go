@@ -0,0 +1,208 @@
+package certController
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// windowsStore Windows 根证书存储
+type windowsStore struct{}
+
+func (s *windowsStore) Name() string {
+	return TrustWindows
+}
+
+func (s *windowsStore) Install(caPath string, ca *x509.Certificate) error {
+	return runCommand("certutil", "-addstore", "-f", "Root", caPath)
+}
+
+func (s *windowsStore) Uninstall(ca *x509.Certificate) error {
+	if ca == nil {
+		// 没有证书时按名称移除，会同时移除旧版本导入的CA
+		return runCommand("certutil", "-delstore", "Root", trustName)
+	}
+	return runCommand("certutil", "-delstore", "Root", ca.SerialNumber.Text(16))
+}
+
+func (s *windowsStore) Contains(ca *x509.Certificate) (bool, error) {
+	// 找不到证书时 certutil 返回非0
+	return runCommand("certutil", "-verifystore", "Root", ca.SerialNumber.Text(16)) == nil, nil
+}
+
+// systemStore 基于证书目录与刷新命令的Linux系统信任库
+type systemStore struct {
+	name      string
+	root      string
+	anchor    string   // 证书文件路径
+	bundle    string   // 刷新后生成的证书包
+	refresh   []string // 刷新命令
+	unrefresh []string // 移除证书后的刷新命令
+}
+
+// newDebianStore Debian/Ubuntu
+func newDebianStore(root string) *systemStore {
+	return &systemStore{
+		name:      TrustDebian,
+		root:      root,
+		anchor:    "/usr/local/share/ca-certificates/" + trustFileName + ".crt",
+		bundle:    "/etc/ssl/certs/ca-certificates.crt",
+		refresh:   []string{"update-ca-certificates"},
+		unrefresh: []string{"update-ca-certificates", "--fresh"},
+	}
+}
+
+// newFedoraStore Fedora/RHEL 与 Arch，两者都使用 p11-kit 的 update-ca-trust
+func newFedoraStore(root string) *systemStore {
+	s := &systemStore{
+		name:      TrustFedora,
+		root:      root,
+		anchor:    "/etc/pki/ca-trust/source/anchors/" + trustFileName + ".pem",
+		bundle:    "/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+		refresh:   []string{"update-ca-trust", "extract"},
+		unrefresh: []string{"update-ca-trust", "extract"},
+	}
+	// Arch
+	if _, err := os.Stat(rooted(root, "/etc/ca-certificates/trust-source")); err == nil {
+		s.anchor = "/etc/ca-certificates/trust-source/anchors/" + trustFileName + ".pem"
+		s.bundle = "/etc/ssl/certs/ca-certificates.crt"
+	}
+	return s
+}
+
+func (s *systemStore) Name() string {
+	return s.name
+}
+
+func (s *systemStore) Install(caPath string, ca *x509.Certificate) error {
+	if err := copyCert(rooted(s.root, s.anchor), ca); err != nil {
+		return err
+	}
+	return s.run(s.refresh)
+}
+
+func (s *systemStore) Uninstall(ca *x509.Certificate) error {
+	err := os.Remove(rooted(s.root, s.anchor))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.run(s.unrefresh)
+}
+
+func (s *systemStore) Contains(ca *x509.Certificate) (bool, error) {
+	ok, err := fileContains(rooted(s.root, s.anchor), ca)
+	if err != nil || !ok {
+		return false, err
+	}
+	// 证书包不存在时只检查证书文件
+	if _, err := os.Stat(rooted(s.root, s.bundle)); errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	return fileContains(rooted(s.root, s.bundle), ca)
+}
+
+// run 执行刷新命令，根目录不是系统根目录时跳过
+func (s *systemStore) run(cmd []string) error {
+	if s.root != "" && s.root != "/" {
+		log.Debugf("根目录为 %s，跳过 %v", s.root, cmd)
+		return nil
+	}
+	return runCommand(cmd[0], cmd[1:]...)
+}
+
+// nssStore NSS数据库（Firefox、Chromium 以及部分Wine前缀）
+type nssStore struct {
+	root  string
+	dbDir string // 不含根目录的数据库目录，记录在安装记录中
+}
+
+func (s *nssStore) Name() string {
+	return TrustNSS + ":" + s.dbDir
+}
+
+// db certutil -d 的参数
+func (s *nssStore) db() string {
+	return "sql:" + rooted(s.root, s.dbDir)
+}
+
+func (s *nssStore) Install(caPath string, ca *x509.Certificate) error {
+	return runCommand("certutil", "-d", s.db(), "-A", "-t", "C,,", "-n", trustName, "-i", caPath)
+}
+
+func (s *nssStore) Uninstall(ca *x509.Certificate) error {
+	return runCommand("certutil", "-d", s.db(), "-D", "-n", trustName)
+}
+
+func (s *nssStore) Contains(ca *x509.Certificate) (bool, error) {
+	output, err := commandOutput("certutil", "-d", s.db(), "-L", "-n", trustName, "-a")
+	if err != nil {
+		return false, nil
+	}
+	for {
+		var block *pem.Block
+		block, output = pem.Decode(output)
+		if block == nil {
+			return false, nil
+		}
+		if bytes.Equal(block.Bytes, ca.Raw) {
+			return true, nil
+		}
+	}
+}
+
+// findNSSDatabases 查找当前用户的NSS数据库，返回的目录不含根目录
+func findNSSDatabases(root string) []string {
+	home, err := userHomeDir()
+	if err != nil {
+		return nil
+	}
+	home = rooted(root, home)
+
+	patterns := []string{
+		filepath.Join(home, ".pki", "nssdb"),
+		filepath.Join(home, ".mozilla", "firefox", "*"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox", "*"),
+	}
+	var dbs []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, dir := range matches {
+			if _, err := os.Stat(filepath.Join(dir, "cert9.db")); err == nil {
+				dbs = append(dbs, unrooted(root, dir))
+			}
+		}
+	}
+	return dbs
+}
+
+// dirStore 只把CA证书复制到目录中，由用户自行导入
+type dirStore struct {
+	dir string
+}
+
+func (s *dirStore) Name() string {
+	return TrustDir + ":" + s.dir
+}
+
+func (s *dirStore) path() string {
+	return filepath.Join(s.dir, trustFileName+".pem")
+}
+
+func (s *dirStore) Install(caPath string, ca *x509.Certificate) error {
+	return copyCert(s.path(), ca)
+}
+
+func (s *dirStore) Uninstall(ca *x509.Certificate) error {
+	err := os.Remove(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *dirStore) Contains(ca *x509.Certificate) (bool, error) {
+	return fileContains(s.path(), ca)
+}
@@ -0,0 +1,337 @@
+package certController
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"idv-login-go/logger"
+)
+
+// fakeSystem 替换外部命令与命令查找，记录执行过的命令
+type fakeSystem struct {
+	commands  []string
+	available map[string]bool // lookPath 能找到的命令
+	output    []byte          // commandOutput 的返回值
+}
+
+func newFakeSystem(t *testing.T, available ...string) *fakeSystem {
+	t.Helper()
+	f := &fakeSystem{available: make(map[string]bool)}
+	for _, name := range available {
+		f.available[name] = true
+	}
+
+	log = logger.GetLogger()
+	oldRun, oldOutput, oldLook, oldHome := runCommand, commandOutput, lookPath, userHomeDir
+	t.Cleanup(func() {
+		runCommand, commandOutput, lookPath, userHomeDir = oldRun, oldOutput, oldLook, oldHome
+	})
+	runCommand = func(name string, args ...string) error {
+		f.commands = append(f.commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+	commandOutput = func(name string, args ...string) ([]byte, error) {
+		f.commands = append(f.commands, strings.Join(append([]string{name}, args...), " "))
+		if f.output == nil {
+			return nil, errors.New("not found")
+		}
+		return f.output, nil
+	}
+	lookPath = func(name string) (string, error) {
+		if f.available[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", errors.New("not found")
+	}
+	userHomeDir = func() (string, error) {
+		return "/home/user", nil
+	}
+	return f
+}
+
+func testCA(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(0x1234),
+		Subject:               pkix.Name{CommonName: trustName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// testNSSDB 假的用户目录下的NSS数据库，按本机的路径分隔符
+var testNSSDB = filepath.FromSlash("/home/user/.pki/nssdb")
+
+func writeFile(t *testing.T, fn string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrustStores(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     string
+		setup     func(root string) // 准备根目录
+		wantName  string
+		anchor    string   // 安装后证书文件的位置（不含根目录），为空时不检查
+		install   []string // Install 执行的命令
+		uninstall []string // Uninstall 执行的命令
+	}{
+		{
+			name:      "debian",
+			store:     TrustDebian,
+			wantName:  TrustDebian,
+			anchor:    "/usr/local/share/ca-certificates/idv-login-go.crt",
+			install:   nil, // 根目录不是 / 时不执行刷新命令
+			uninstall: nil,
+		},
+		{
+			name:     "fedora",
+			store:    TrustFedora,
+			wantName: TrustFedora,
+			anchor:   "/etc/pki/ca-trust/source/anchors/idv-login-go.pem",
+		},
+		{
+			name:  "arch",
+			store: TrustFedora,
+			setup: func(root string) {
+				if err := os.MkdirAll(filepath.Join(root, "/etc/ca-certificates/trust-source"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantName: TrustFedora,
+			anchor:   "/etc/ca-certificates/trust-source/anchors/idv-login-go.pem",
+		},
+		{
+			name:     "dir",
+			store:    TrustDir,
+			wantName: TrustDir + ":<dir>",
+		},
+		{
+			name:  "nss",
+			store: TrustNSS,
+			setup: func(root string) {
+				writeFile(t, filepath.Join(root, testNSSDB, "cert9.db"), nil)
+			},
+			wantName:  TrustNSS + ":<nssdb>",
+			install:   []string{"certutil -d sql:<root><nssdb> -A -t C,, -n Login Helper GO -i <ca>"},
+			uninstall: []string{"certutil -d sql:<root><nssdb> -D -n Login Helper GO"},
+		},
+		{
+			name:      "windows",
+			store:     TrustWindows,
+			wantName:  TrustWindows,
+			install:   []string{"certutil -addstore -f Root <ca>"},
+			uninstall: []string{"certutil -delstore Root 1234"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeSystem(t)
+			root := t.TempDir()
+			dir := filepath.Join(t.TempDir(), "trust")
+			if tt.setup != nil {
+				tt.setup(root)
+			}
+			ca := testCA(t)
+			caPath := filepath.Join(t.TempDir(), "ca.pem")
+			writeFile(t, caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+			replacer := strings.NewReplacer("<root>", root, "<nssdb>", testNSSDB, "<dir>", dir, "<ca>", caPath)
+
+			stores, err := NewTrustStore(tt.store, TrustOptions{Root: root, Dir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stores) != 1 {
+				t.Fatalf("got %d stores, want 1", len(stores))
+			}
+			store := stores[0]
+			if want := replacer.Replace(tt.wantName); store.Name() != want {
+				t.Errorf("Name() = %q, want %q", store.Name(), want)
+			}
+
+			// 按安装记录中的名称应能找到同一个后端
+			found, err := FindTrustStore(store.Name(), TrustOptions{Root: root, Dir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(found, store) {
+				t.Errorf("FindTrustStore(%q) = %#v, want %#v", store.Name(), found, store)
+			}
+
+			if err := store.Install(caPath, ca); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := f.commands, replaceAll(replacer, tt.install); !reflect.DeepEqual(got, want) {
+				t.Errorf("Install commands = %q, want %q", got, want)
+			}
+			if tt.anchor != "" {
+				if ok, err := fileContains(filepath.Join(root, tt.anchor), ca); err != nil || !ok {
+					t.Errorf("%s does not contain the CA: %v", tt.anchor, err)
+				}
+			}
+			if tt.store == TrustDir || tt.anchor != "" {
+				if ok, err := store.Contains(ca); err != nil || !ok {
+					t.Errorf("Contains() = %v, %v after Install", ok, err)
+				}
+			}
+
+			f.commands = nil
+			if err := store.Uninstall(ca); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := f.commands, replaceAll(replacer, tt.uninstall); !reflect.DeepEqual(got, want) {
+				t.Errorf("Uninstall commands = %q, want %q", got, want)
+			}
+			if tt.anchor != "" {
+				if _, err := os.Stat(filepath.Join(root, tt.anchor)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s still exists after Uninstall", tt.anchor)
+				}
+			}
+			if tt.store == TrustDir || tt.anchor != "" {
+				if ok, err := store.Contains(ca); err != nil || ok {
+					t.Errorf("Contains() = %v, %v after Uninstall", ok, err)
+				}
+			}
+		})
+	}
+}
+
+func replaceAll(r *strings.Replacer, commands []string) []string {
+	if commands == nil {
+		return nil
+	}
+	out := make([]string, len(commands))
+	for i, c := range commands {
+		out[i] = r.Replace(c)
+	}
+	return out
+}
+
+func TestSystemStoreRefresh(t *testing.T) {
+	f := newFakeSystem(t)
+	s := newDebianStore("/")
+	s.anchor = filepath.Join(t.TempDir(), "idv-login-go.crt") // 不写入真实的系统目录
+	ca := testCA(t)
+	if err := s.Install("", ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Uninstall(ca); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"update-ca-certificates", "update-ca-certificates --fresh"}
+	if !reflect.DeepEqual(f.commands, want) {
+		t.Errorf("commands = %q, want %q", f.commands, want)
+	}
+}
+
+func TestNSSContains(t *testing.T) {
+	f := newFakeSystem(t)
+	root := t.TempDir()
+	ca := testCA(t)
+	s := &nssStore{root: root, dbDir: testNSSDB}
+
+	if ok, err := s.Contains(ca); err != nil || ok {
+		t.Errorf("Contains() = %v, %v when certutil fails", ok, err)
+	}
+	f.output = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	if ok, err := s.Contains(ca); err != nil || !ok {
+		t.Errorf("Contains() = %v, %v when certutil lists the CA", ok, err)
+	}
+	want := "certutil -d sql:" + filepath.Join(root, testNSSDB) + " -L -n Login Helper GO -a"
+	if f.commands[0] != want {
+		t.Errorf("command = %q, want %q", f.commands[0], want)
+	}
+}
+
+func TestDetectTrustStores(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 上只使用系统证书存储，见 TestDetectTrustStoresWindows")
+	}
+	tests := []struct {
+		name      string
+		available []string
+		nssdb     bool
+		dir       bool
+		want      []string
+	}{
+		{name: "none", want: nil},
+		{name: "debian", available: []string{"update-ca-certificates", "update-ca-trust"}, want: []string{TrustDebian}},
+		{name: "fedora", available: []string{"update-ca-trust"}, want: []string{TrustFedora}},
+		{name: "nss without certutil", available: []string{"update-ca-trust"}, nssdb: true, want: []string{TrustFedora}},
+		{name: "nss", available: []string{"certutil"}, nssdb: true, want: []string{TrustNSS + ":<nssdb>"}},
+		{name: "dir", available: []string{"update-ca-certificates"}, dir: true, want: []string{TrustDebian, TrustDir + ":<dir>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeSystem(t, tt.available...)
+			root := t.TempDir()
+			if tt.nssdb {
+				writeFile(t, filepath.Join(root, testNSSDB, "cert9.db"), nil)
+			}
+			opts := TrustOptions{Root: root}
+			if tt.dir {
+				opts.Dir = filepath.Join(root, "trust")
+			}
+			var got []string
+			for _, s := range detectTrustStores(opts) {
+				got = append(got, s.Name())
+			}
+			want := replaceAll(strings.NewReplacer("<nssdb>", testNSSDB, "<dir>", opts.Dir), tt.want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("stores = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDetectTrustStoresWindows(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("只在 Windows 上检测系统证书存储")
+	}
+	newFakeSystem(t, "certutil", "update-ca-certificates")
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, testNSSDB, "cert9.db"), nil)
+	var got []string
+	for _, s := range detectTrustStores(TrustOptions{Root: root, Dir: filepath.Join(root, "trust")}) {
+		got = append(got, s.Name())
+	}
+	if want := []string{TrustWindows}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stores = %q, want %q", got, want)
+	}
+}
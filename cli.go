@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"idv-login-go/admin"
+	"idv-login-go/app"
+	"idv-login-go/constants"
+	"idv-login-go/dnsController"
+	"idv-login-go/hostsController"
+	"idv-login-go/server"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// command 命令行子命令
+type command struct {
+	name  string
+	args  string
+	usage string
+	admin bool // 是否需要管理员权限
+	// adminArgs 带这些参数时需要管理员权限
+	adminArgs []string
+	run       func(args []string) int
+}
+
+// needsAdmin 以 args 运行时是否需要管理员权限
+func (c *command) needsAdmin(args []string) bool {
+	if c.admin {
+		return true
+	}
+	for _, arg := range args {
+		for _, adminArg := range c.adminArgs {
+			if arg == adminArg || strings.HasPrefix(arg, adminArg+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var commands = []*command{
+	{name: "run", usage: "在前台运行代理，不显示托盘，收到 Ctrl+C 或 SIGTERM 时退出并清理", admin: true, run: cmdRun},
+	{name: "status", usage: "显示hosts、证书与端口的状态", run: cmdStatus},
+	{name: "install-ca", usage: "检查证书，生成或修复后将CA证书导入信任库", admin: true, run: cmdInstallCA},
+	{name: "uninstall", usage: "移除CA证书、程序生成的文件与hosts记录", admin: true, run: cmdUninstall},
+	{name: "hosts", args: "add|remove|restore [备份|original]|backups", usage: "修改hosts或从备份还原，original 为第一次写入前的备份", admin: true, run: cmdHosts},
+	{name: "resolve", args: "[主机名...]", usage: "解析上游IP，默认解析全部拦截的主机名", run: cmdResolve},
+	{name: "gen-cert", args: "[--force]", usage: "生成证书文件但不导入信任库，--force 时移除已导入的CA后重新生成", adminArgs: []string{"-force", "--force"}, run: cmdGenCert},
+}
+
+// findCommand 按名称查找子命令
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// printUsage 输出用法
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "用法：%s [选项] [命令]\n\n不带命令时运行托盘（使用 notray 标签编译时等同于 run）\n\n命令：\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %s\n    \t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.usage)
+	}
+	fmt.Fprintln(out, "\n选项：")
+	flag.PrintDefaults()
+}
+
+// cmdRun 前台运行，收到退出信号或代理服务器退出时停止
+func cmdRun(args []string) int {
+	if !recoverHosts() {
+		return 1
+	}
+
+	a := app.New()
+	events, unsubscribe := a.Subscribe()
+	defer unsubscribe()
+	defer startAdmin(a)()
+	if err := a.Start(); err != nil {
+		return 1
+	}
+	log.Info("代理已启动，按 Ctrl+C 退出")
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case s := <-sig:
+			log.Infof("收到 %v，正在退出", s)
+			if err := a.Stop(); err != nil {
+				return 1
+			}
+			return 0
+		case e := <-events:
+			// 运行中意外退出时已回滚
+			if e.Kind == app.EventState && e.State == app.StateFailed {
+				return 1
+			}
+		}
+	}
+}
+
+// recoverHosts 移除上次运行崩溃或被结束时遗留的hosts记录，另一个实例正在运行时返回 false
+func recoverHosts() bool {
+	if err := hostsController.New().Recover(); err != nil {
+		log.Error(err)
+		return false
+	}
+	return true
+}
+
+// startAdmin 开启管理接口时在后台启动，返回的函数用于关闭；启动失败不影响代理
+func startAdmin(a *app.App) func() {
+	if !conf.Bool("adminEnabled") {
+		return func() {}
+	}
+	s := admin.New(a)
+	if err := s.Start(); err != nil {
+		log.Errorf("启动管理接口失败：%v", err)
+		return func() {}
+	}
+	return func() {
+		s.Shutdown()
+	}
+}
+
+// cmdStatus 显示当前状态
+func cmdStatus(args []string) int {
+	fmt.Printf("重定向方式：%s\n", conf.String("redirectMode"))
+	fmt.Printf("拦截的主机名：%s\n", strings.Join(conf.Hosts(), ", "))
+
+	hostC := hostsController.New()
+	switch {
+	case hostC.Exist():
+		fmt.Println("hosts：已写入")
+	case hostC.Managed():
+		fmt.Println("hosts：已写入，但与当前配置不一致")
+	default:
+		fmt.Println("hosts：未写入")
+	}
+	if _, err := os.Stat(constants.HostsJournalPath); err == nil {
+		fmt.Printf("hosts日志：%s 存在，程序正在运行或上次未正常退出\n", constants.HostsJournalPath)
+	}
+	if backups, err := hostsController.Backups(); err == nil && len(backups) > 0 {
+		fmt.Printf("hosts备份：%d 份，最新 %s\n", len(backups), backups[len(backups)-1])
+	}
+
+	code := 0
+	certM, err := app.NewCertController()
+	if err != nil {
+		fmt.Printf("证书：%v\n", err)
+		code = 1
+	} else if health := certM.Check(app.CertFiles()); health.OK() {
+		fmt.Printf("证书：正常，CA有效期至 %s，网站证书有效期至 %s\n",
+			certM.CaCert.NotAfter.Format(time.DateTime), certM.WebCert.NotAfter.Format(time.DateTime))
+	} else {
+		fmt.Println("证书：")
+		for _, problem := range health.Problems {
+			fmt.Printf("  %s\n", problem)
+		}
+		code = 1
+	}
+
+	listen := conf.Strings("listen")
+	if len(listen) == 0 {
+		listen = server.DefaultListen
+	}
+	for _, addr := range listen {
+		addr, err := server.NormalizeListen(addr)
+		if err == nil {
+			err = server.CheckListen(addr)
+		}
+		if err != nil {
+			fmt.Printf("监听地址：%v\n", err)
+		} else {
+			fmt.Printf("监听地址 %s：空闲\n", addr)
+		}
+	}
+	return code
+}
+
+// cmdInstallCA 检查并修复证书，导入CA证书
+func cmdInstallCA(args []string) int {
+	if _, err := app.PrepareCert(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("CA证书已安装：%s\n", constants.CaPath)
+	return 0
+}
+
+// cmdUninstall 卸载
+func cmdUninstall(args []string) int {
+	if report := uninstall(); report.failed() {
+		return 1
+	}
+	return 0
+}
+
+// cmdHosts 修改hosts
+func cmdHosts(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法：hosts add|remove|restore [备份|original]|backups")
+		return 2
+	}
+	hostC := hostsController.New()
+	switch args[0] {
+	case "add":
+		if !hostC.Add() {
+			return 1
+		}
+		fmt.Println("hosts已写入")
+	case "remove":
+		if !hostC.Remove() {
+			return 1
+		}
+		fmt.Println("hosts已移除")
+	case "restore":
+		var backup string
+		if len(args) > 1 {
+			backup = args[1]
+		}
+		if err := hostC.Restore(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "还原hosts失败：%v\n", err)
+			return 1
+		}
+	case "backups":
+		backups, err := hostsController.Backups()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "读取备份失败：%v\n", err)
+			return 1
+		}
+		if _, err := os.Stat(hostsController.OriginalBackup); err == nil {
+			fmt.Printf("%s（第一次写入前）\n", hostsController.OriginalBackup)
+		}
+		for _, backup := range backups {
+			fmt.Println(backup)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "未知的hosts操作 %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// cmdResolve 解析上游IP
+func cmdResolve(args []string) int {
+	hosts := args
+	if len(hosts) == 0 {
+		hosts = conf.Hosts()
+	}
+	code := 0
+	for _, host := range hosts {
+		result, err := resolve(host)
+		if err != nil {
+			fmt.Printf("%s：解析失败：%v\n", host, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s（来自 %s，TTL %s）：%s\n", host, result.Provider, result.MinTTL(), strings.Join(result.IPs(), ", "))
+	}
+	return code
+}
+
+func resolve(host string) (*dnsController.Result, error) {
+	dnsC, err := dnsController.NewDnsController(host)
+	if err != nil {
+		return nil, err
+	}
+	return dnsC.Resolve()
+}
+
+// cmdGenCert 生成证书文件，用于将CA证书手动导入其他设备
+func cmdGenCert(args []string) int {
+	fs := flag.NewFlagSet("gen-cert", flag.ContinueOnError)
+	force := fs.Bool("force", false, "移除已导入的CA证书后重新生成")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	certM, err := app.NewCertController()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *force && !removeTrustedCA() {
+		return 1
+	}
+	health := certM.Check(app.CertFiles())
+	if err := certM.Generate(app.CertFiles(), *force || health.NeedsCA()); err != nil {
+		fmt.Fprintf(os.Stderr, "生成证书失败：%v\n", err)
+		return 1
+	}
+	fmt.Printf("CA证书：%s\n网站证书：%s\n", constants.CaPath, constants.CertPath)
+	fmt.Println("CA证书未导入信任库，本机使用时请运行 install-ca")
+	return 0
+}
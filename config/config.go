@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/file"
@@ -9,7 +10,9 @@ import (
 	"idv-login-go/constants"
 	"idv-login-go/logger"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var once sync.Once
@@ -22,14 +25,119 @@ var defaultConf = map[string]interface{}{
 	"host":      "service.mkey.163.com",
 	"hostDNS":   "https://dns.alidns.com/resolve",
 	"defaultIP": "42.186.193.21",
+	// 除 host 外需要拦截的主机名，每个主机名各自解析上游，并加入hosts/本地DNS与证书；默认IP只作用于 host
+	"extraHosts": []string{},
+	// 解析服务，依次为 RFC 8484 DoH、JSON API DoH（json+https://）与普通DNS（udp://、tcp://）
+	// 留空时：修改过 hostDNS 则只使用 hostDNS（兼容旧配置），否则使用 AliDNS、DNSPod 与 223.5.5.5
+	"dnsProviders": []string{},
+	// 解析策略：failover 按顺序尝试，race 同时查询取最快的结果
+	"dnsStrategy": "failover",
+	// 单个解析服务的超时时间（秒）
+	"dnsTimeout": 3,
+	// 是否同时解析IPv6地址
+	"dnsIPv6": false,
+	// ECS子网：auto 按 ecsSources 获取公网IP，manual 使用 ecsSubnet，off 不发送；IPv4截断为/24，IPv6截断为/56
+	"ecsMode": "auto",
+	// 公网IP来源，依次尝试：ipcn/ipify/ipsb/cloudflare、自定义 https:// 地址或 stun:主机:端口
+	"ecsSources": []string{"ipcn", "ipsb", "stun:stun.miwifi.com:3478"},
+	"ecsSubnet":  "",
+	// 单个公网IP来源的超时时间（秒）
+	"ecsTimeout": 3,
+	// 启动时对每个上游IP进行TLS握手探测的次数与超时时间（秒），按延迟选择最快的IP
+	"upstreamProbeCount":   2,
+	"upstreamProbeTimeout": 3,
+	// 后台健康检查间隔（秒），为0时不检查
+	"upstreamHealthInterval": 30,
+	// 上游IP连续失败多少次后熔断，以及熔断的冷却时间（秒）
+	"upstreamBreakerThreshold": 3,
+	"upstreamBreakerCooldown":  30,
+	// GET等幂等请求失败后换IP重试的次数
+	"upstreamRetries": 1,
+	// 上游证书的SPKI固定列表，按主机名填写，如 upstreamPins."service.mkey.163.com" = ["sha256/<base64>"]
+	// 没有填写的主机名只按系统根证书校验证书链与主机名
+	"upstreamPins": map[string]interface{}{},
+	// 除host外允许按SNI签发证书的主机名，支持 *.example.com，修改后会重新生成CA
+	"sniAllowList": []string{},
+	// 证书密钥算法：rsa/ecdsa/ed25519
+	"certKeyType": "ecdsa",
+	// 网站证书有效期（天），剩余三分之一时自动续期
+	"certLeafDays": 30,
+	// 导入CA证书的信任库：auto/windows/debian/fedora/nss/dir
+	"trustStores": []string{"auto"},
+	// dir 信任库的目录，auto 时填写后也会复制一份
+	"trustDir": "",
+	// nss 信任库的数据库目录，留空时自动查找 ~/.pki/nssdb 与 Firefox 配置
+	"nssDatabases": []string{},
+	// 重定向方式：hosts 修改hosts文件，dns 启动本地DNS服务器，需要将系统或Wine前缀的DNS指向 dnsListen
+	"redirectMode": "hosts",
+	// 代理服务器监听地址，默认只监听本机，可填写多个，如 "[::1]:443"；"0.0.0.0:443" 会暴露给局域网
+	// hosts与本地DNS将主机名指向 127.0.0.1，需要包含 127.0.0.1:443 或通配地址
+	"listen": []string{"127.0.0.1:443"},
+	// 本地DNS服务器监听地址
+	"dnsListen": "127.0.0.1:53",
+	// 本地DNS服务器的上游，未拦截的查询转发到这里
+	"dnsUpstream": []string{"223.5.5.5:53", "119.29.29.29:53"},
+	// HAR记录：开启后将每次代理的请求与响应（改写前后）保存到 harDir，用于排查登录问题
+	// 记录中包含登录凭据，请勿随意分享
+	"harCapture": false,
+	"harDir":     "./har",
+	// HAR文件的总大小上限与单个文件的大小上限（MB），超出总大小时删除最旧的文件
+	"harMaxMB":  100,
+	"harFileMB": 10,
+	// 每个请求体或响应体最多记录的大小（KB）
+	"harBodyKB": 1024,
+	// 离线回放：填写HAR文件后不再请求上游，按记录的上游响应返回，用于测试改写规则
+	"replayFiles": []string{},
+	// 未匹配请求的处理方式：strict 返回错误，loose 只按方法与路径匹配，passthrough 同 loose，仍未匹配时请求真实上游
+	"replayMatch": "strict",
+	// 匹配时忽略的参数，如时间戳、签名
+	"replayIgnoreParams": []string{},
+	// 本地管理接口：查询状态、启停代理、重新加载配置、查看最近的日志与请求，/metrics 导出 Prometheus 指标，只能监听回环地址
+	"adminEnabled": false,
+	"adminListen":  "127.0.0.1:9180",
+	// 管理接口的令牌，留空时自动生成并保存到 idv_admin_token
+	"adminToken": "",
+	// 改写规则
+	"defaultRules": true,
+	"rulesPath":    "./rules.toml",
 }
 
+// Config 当前配置，Reload 时整体替换，读取与重新加载可以并发进行
 type Config struct {
-	*koanf.Koanf
+	k atomic.Pointer[koanf.Koanf]
+}
+
+// Koanf 当前配置的快照，需要读取多项且保证一致时使用
+func (c *Config) Koanf() *koanf.Koanf {
+	return c.k.Load()
+}
+
+func (c *Config) String(path string) string {
+	return c.Koanf().String(path)
+}
+
+func (c *Config) Strings(path string) []string {
+	return c.Koanf().Strings(path)
+}
+
+func (c *Config) Int(path string) int {
+	return c.Koanf().Int(path)
+}
+
+func (c *Config) Int64(path string) int64 {
+	return c.Koanf().Int64(path)
+}
+
+func (c *Config) Bool(path string) bool {
+	return c.Koanf().Bool(path)
+}
+
+func (c *Config) Unmarshal(path string, o interface{}) error {
+	return c.Koanf().Unmarshal(path, o)
 }
 
 func (c *Config) Save() bool {
-	bytes, err := c.Marshal(toml.Parser())
+	bytes, err := c.Koanf().Marshal(toml.Parser())
 	if err != nil {
 		log.Errorf("转换为字节码失败：%v", err)
 		return false
@@ -54,28 +162,72 @@ func (c *Config) Save() bool {
 	return true
 }
 
+// Hosts 需要拦截的全部主机名，host 在最前，已去重
+func (c *Config) Hosts() []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	k := c.Koanf()
+	for _, host := range append([]string{k.String("host")}, k.Strings("extraHosts")...) {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// load 载入默认配置与配置文件，配置文件中的值会覆盖默认值，保证旧配置文件缺少的新配置项也有值
+func load() (*koanf.Koanf, error) {
+	k := koanf.New(".")
+	if err := k.Load(confmap.Provider(defaultConf, "."), nil); err != nil {
+		log.Fatalf("加载默认配置失败：%v", err)
+	}
+	if err := k.Load(file.Provider(configPath), toml.Parser()); err != nil {
+		return k, err
+	}
+	return k, nil
+}
+
+// applyLogLevel 按 debug 改变log等级
+func applyLogLevel() {
+	constants.DebugMode = instance.Bool("debug")
+	if constants.DebugMode {
+		log.SetLevel(logrus.DebugLevel)
+	} else {
+		log.SetLevel(logrus.InfoLevel)
+	}
+	log.Infof("debug模式：%v", constants.DebugMode)
+}
+
+// Reload 重新读取配置文件，读取失败时保留当前配置
+// 正在运行的代理不会自动应用新配置，需要重启
+func Reload() error {
+	GetConfig()
+	k, err := load()
+	if err != nil {
+		return fmt.Errorf("加载配置文件失败：%w", err)
+	}
+	instance.k.Store(k)
+	log.Info("重新加载配置文件成功")
+	applyLogLevel()
+	return nil
+}
+
 func GetConfig() *Config {
 	once.Do(func() {
 		log = logger.GetLogger()
-		instance = &Config{koanf.New(".")}
-		if err := instance.Load(file.Provider(configPath), toml.Parser()); err != nil {
+		k, err := load()
+		instance = &Config{}
+		instance.k.Store(k)
+		if err != nil {
 			log.Errorf("加载配置文件失败：%v", err)
 			log.Info("将使用默认配置")
-
-			err = instance.Load(confmap.Provider(defaultConf, "."), nil)
-			if err != nil {
-				log.Fatalf("加载默认配置失败：%v", err)
-			}
 			instance.Save()
 		}
 		log.Info("加载配置文件成功")
-
-		// 改变log等级
-		if instance.Bool("debug") {
-			log.SetLevel(logrus.DebugLevel)
-			constants.DebugMode = true
-		}
-		log.Infof("debug模式：%v", constants.DebugMode)
+		applyLogLevel()
 	})
 	return instance
 }
@@ -2,13 +2,20 @@ package constants
 
 const (
 	CaPath    = "./idv_ca.pem"
+	CaKeyPath = "./idv_ca_key.pem"
 	CertPath  = "./idv_cert.pem"
 	KeyPath   = "./idv_key.pem"
-	IpHost    = "https://www.ip.cn/api/index"
 	Icv       = "i3.15.0"
 	Pcv       = "p3.15.0"
 	Ccv       = "c3.15.0"
 	Localhost = "127.0.0.1"
+
+	TrustRecordPath     = "./idv_trust.json"     // CA证书的安装记录
+	UninstallReportPath = "./idv_uninstall.json" // 卸载报告
+	DnsCachePath        = "./idv_dns.json"       // 最近一次成功的解析结果
+	HostsJournalPath    = "./idv_hosts.json"     // 写入hosts的记录，正常移除时删除
+	HostsBackupDir      = "./idv_hosts_backup"   // 修改hosts前的备份
+	AdminTokenPath      = "./idv_admin_token"    // 管理接口的令牌，未配置 adminToken 时自动生成
 )
 
 var (
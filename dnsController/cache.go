@@ -0,0 +1,134 @@
+package dnsController
+
+import (
+	"errors"
+	"github.com/goccy/go-json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache 解析结果缓存，内存中按TTL过期，成功的结果同时写入磁盘作为离线时的后备
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	result  *Result
+	expires time.Time
+}
+
+// lastGood 磁盘上保存的最近一次成功的解析结果
+type lastGood struct {
+	IPs       []string  `json:"ips"`
+	Provider  string    `json:"provider"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// NewCache path 为磁盘缓存文件，为空时不持久化
+func NewCache(path string) *Cache {
+	return &Cache{path: path, entries: make(map[string]cacheEntry)}
+}
+
+// Get 未过期的缓存结果
+func (c *Cache) Get(host string) (*Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[normalizeName(host)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// Put 按最短的TTL缓存结果，并写入磁盘
+func (c *Cache) Put(result *Result) {
+	host := normalizeName(result.Host)
+	if ttl := result.MinTTL(); ttl > 0 {
+		c.mu.Lock()
+		c.entries[host] = cacheEntry{result: result, expires: time.Now().Add(ttl)}
+		c.mu.Unlock()
+	}
+	if err := c.save(host, result); err != nil {
+		log.Warnf("保存解析缓存失败：%v", err)
+	}
+}
+
+// LastGood 磁盘上最近一次成功的解析结果及其时间
+func (c *Cache) LastGood(host string) (*Result, time.Time, bool) {
+	all, err := c.load()
+	if err != nil {
+		log.Warnf("读取解析缓存失败：%v", err)
+		return nil, time.Time{}, false
+	}
+	entry, ok := all[normalizeName(host)]
+	if !ok || len(entry.IPs) == 0 {
+		return nil, time.Time{}, false
+	}
+	result := &Result{Host: host, Provider: entry.Provider}
+	for _, ip := range entry.IPs {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			result.Records = append(result.Records, Record{IP: parsed})
+		}
+	}
+	if len(result.Records) == 0 {
+		return nil, time.Time{}, false
+	}
+	return result, entry.UpdatedAt, true
+}
+
+func (c *Cache) load() (map[string]lastGood, error) {
+	all := make(map[string]lastGood)
+	if c.path == "" {
+		return all, nil
+	}
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// save 先写临时文件再重命名，避免写入中途退出导致缓存损坏
+func (c *Cache) save(host string, result *Result) error {
+	if c.path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	all, err := c.load()
+	if err != nil {
+		all = make(map[string]lastGood)
+	}
+	all[host] = lastGood{IPs: result.IPs(), Provider: result.Provider, UpdatedAt: time.Now()}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
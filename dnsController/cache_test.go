@@ -0,0 +1,80 @@
+package dnsController
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"idv-login-go/logger"
+)
+
+func TestCache(t *testing.T) {
+	log = logger.GetLogger()
+	path := filepath.Join(t.TempDir(), "dns-cache.json")
+	c := NewCache(path)
+
+	if _, ok := c.Get("service.mkey.163.com"); ok {
+		t.Fatal("empty cache returned a result")
+	}
+	if _, _, ok := c.LastGood("service.mkey.163.com"); ok {
+		t.Fatal("missing cache file returned a result")
+	}
+
+	c.Put(&Result{Host: "Service.MKey.163.com.", Provider: "test", Records: []Record{
+		{IP: net.ParseIP("2001:db8::1"), TTL: time.Minute},
+		{IP: net.ParseIP("203.0.113.7"), TTL: 30 * time.Second},
+	}})
+	got, ok := c.Get("service.mkey.163.com")
+	if !ok || got.MinTTL() != 30*time.Second {
+		t.Fatalf("Get = %v, %v", got, ok)
+	}
+
+	// 新实例只能从磁盘读到最近一次成功的结果
+	c = NewCache(path)
+	if _, ok := c.Get("service.mkey.163.com"); ok {
+		t.Error("memory cache should not survive a new instance")
+	}
+	good, updated, ok := c.LastGood("SERVICE.mkey.163.com")
+	if !ok || good.Provider != "test" || time.Since(updated) > time.Minute {
+		t.Fatalf("LastGood = %v, %v, %v", good, updated, ok)
+	}
+	if ips := good.IPs(); !slices.Equal(ips, []string{"203.0.113.7", "2001:db8::1"}) {
+		t.Errorf("LastGood IPs = %v", ips)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
+
+func TestCacheZeroTTL(t *testing.T) {
+	log = logger.GetLogger()
+	path := filepath.Join(t.TempDir(), "dns-cache.json")
+	c := NewCache(path)
+	c.Put(&Result{Host: "a.example.com", Records: []Record{{IP: net.ParseIP("203.0.113.7")}}})
+	if _, ok := c.Get("a.example.com"); ok {
+		t.Error("zero TTL result should not be cached in memory")
+	}
+	if _, _, ok := c.LastGood("a.example.com"); !ok {
+		t.Error("zero TTL result should still be saved to disk")
+	}
+}
+
+func TestCacheCorrupt(t *testing.T) {
+	log = logger.GetLogger()
+	path := filepath.Join(t.TempDir(), "dns-cache.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(path)
+	if _, _, ok := c.LastGood("a.example.com"); ok {
+		t.Fatal("corrupt cache returned a result")
+	}
+	// 损坏的缓存文件在下次写入时被覆盖
+	c.Put(&Result{Host: "a.example.com", Records: []Record{{IP: net.ParseIP("203.0.113.7"), TTL: time.Minute}}})
+	if _, _, ok := c.LastGood("a.example.com"); !ok {
+		t.Error("cache was not rewritten")
+	}
+}
@@ -0,0 +1,214 @@
+package dnsController
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/imroc/req/v3"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ECS子网的获取方式
+const (
+	EcsAuto   = "auto"   // 按 ecsSources 依次获取公网IP
+	EcsManual = "manual" // 使用 ecsSubnet
+	EcsOff    = "off"    // 不发送ECS
+)
+
+// discoveryTTL 公网IP的缓存时间，discoveryRetry 获取失败后多久再重试，期间直接返回上次的错误
+const (
+	discoveryTTL   = time.Hour
+	discoveryRetry = 5 * time.Minute
+)
+
+// Discoverer 获取本机公网IP
+type Discoverer interface {
+	Name() string
+	Discover(ctx context.Context) (net.IP, error)
+}
+
+// echoServices 内置的HTTP回显服务
+var echoServices = map[string]*httpDiscoverer{
+	"ipcn":       {url: "https://www.ip.cn/api/index?type=0", parse: parseJSONIP},
+	"ipify":      {url: "https://api.ipify.org?format=json", parse: parseJSONIP},
+	"ipsb":       {url: "https://api-ipv4.ip.sb/ip", parse: parsePlainIP},
+	"cloudflare": {url: "https://1.1.1.1/cdn-cgi/trace", parse: parseTraceIP},
+}
+
+// NewDiscoverer 按名称创建：
+//
+//	ipcn/ipify/ipsb/cloudflare        内置的HTTP回显服务
+//	https://example.com/ip            自定义HTTP回显服务，响应为纯文本或 {"ip": "..."}
+//	stun:stun.miwifi.com:3478         STUN服务器
+func NewDiscoverer(spec string) (Discoverer, error) {
+	spec = strings.TrimSpace(spec)
+	if service, ok := echoServices[strings.ToLower(spec)]; ok {
+		return &httpDiscoverer{name: spec, url: service.url, parse: service.parse, client: req.C()}, nil
+	}
+	if server, ok := strings.CutPrefix(spec, "stun:"); ok {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "3478")
+		}
+		return &stunDiscoverer{server: server}, nil
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &httpDiscoverer{name: spec, url: spec, parse: parseAnyIP, client: req.C()}, nil
+	}
+	return nil, fmt.Errorf("未知的公网IP来源 %q", spec)
+}
+
+// Discovery 依次尝试多个来源获取公网IP，结果截断为子网并缓存
+type Discovery struct {
+	sources []Discoverer
+	timeout time.Duration
+
+	mu      sync.Mutex
+	subnet  string
+	err     error // 上次获取失败的原因
+	expires time.Time
+}
+
+// NewDiscovery timeout 为单个来源的超时时间
+func NewDiscovery(specs []string, timeout time.Duration) (*Discovery, error) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	d := &Discovery{timeout: timeout}
+	for _, spec := range specs {
+		source, err := NewDiscoverer(spec)
+		if err != nil {
+			return nil, err
+		}
+		d.sources = append(d.sources, source)
+	}
+	if len(d.sources) == 0 {
+		return nil, errors.New("没有可用的公网IP来源")
+	}
+	return d, nil
+}
+
+// Subnet 公网IP所在的子网，IPv4取/24，IPv6取/56
+func (d *Discovery) Subnet(ctx context.Context) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Now().Before(d.expires) {
+		return d.subnet, d.err
+	}
+
+	var errs []error
+	for _, source := range d.sources {
+		sourceCtx, cancel := context.WithTimeout(ctx, d.timeout)
+		ip, err := source.Discover(sourceCtx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s：%w", source.Name(), err))
+			continue
+		}
+		d.subnet, d.err = Truncate(ip), nil
+		d.expires = time.Now().Add(discoveryTTL)
+		log.Infof("公网IP来自 %s，ECS子网：%s", source.Name(), d.subnet)
+		return d.subnet, nil
+	}
+	d.subnet, d.err = "", errors.Join(errs...)
+	d.expires = time.Now().Add(discoveryRetry)
+	return "", d.err
+}
+
+// Truncate 将IP截断为子网，避免向解析服务暴露完整的IP
+func Truncate(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(56, 128)), Mask: net.CIDRMask(56, 128)}).String()
+}
+
+// ManualSubnet 解析手动填写的IP或CIDR，前缀比/24或/56更长时同样截断
+func ManualSubnet(subnet string) (string, error) {
+	subnet = strings.TrimSpace(subnet)
+	if ip := net.ParseIP(subnet); ip != nil {
+		return Truncate(ip), nil
+	}
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", fmt.Errorf("无效的ECS子网 %q", subnet)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if (bits == 32 && ones > 24) || (bits == 128 && ones > 56) {
+		return Truncate(ipNet.IP), nil
+	}
+	return ipNet.String(), nil
+}
+
+// httpDiscoverer HTTP回显服务
+type httpDiscoverer struct {
+	name   string
+	url    string
+	parse  func(body []byte) (net.IP, error)
+	client *req.Client
+}
+
+func (d *httpDiscoverer) Name() string {
+	return d.name
+}
+
+func (d *httpDiscoverer) Discover(ctx context.Context) (net.IP, error) {
+	resp, err := d.client.R().SetContext(ctx).Get(d.url)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	body, err := resp.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	return d.parse(body)
+}
+
+// parseJSONIP 解析 {"ip": "..."}
+func parseJSONIP(body []byte) (net.IP, error) {
+	var result struct {
+		Ip string `json:"ip"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return parseIP(result.Ip)
+}
+
+// parsePlainIP 解析纯文本IP
+func parsePlainIP(body []byte) (net.IP, error) {
+	return parseIP(string(body))
+}
+
+// parseTraceIP 解析 Cloudflare /cdn-cgi/trace 中的 ip= 行
+func parseTraceIP(body []byte) (net.IP, error) {
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "ip="); ok {
+			return parseIP(value)
+		}
+	}
+	return nil, errors.New("响应中没有 ip=")
+}
+
+// parseAnyIP 自定义服务，按JSON或纯文本解析
+func parseAnyIP(body []byte) (net.IP, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return parseJSONIP(body)
+	}
+	return parsePlainIP(body)
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return nil, fmt.Errorf("无效的IP %q", strings.TrimSpace(s))
+	}
+	return ip, nil
+}
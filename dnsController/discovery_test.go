@@ -0,0 +1,43 @@
+package dnsController
+
+import (
+	"net"
+	"testing"
+)
+
+func TestManualSubnet(t *testing.T) {
+	tests := []struct {
+		subnet  string
+		want    string
+		wantErr bool
+	}{
+		{subnet: "203.0.113.77", want: "203.0.113.0/24"},
+		{subnet: " 203.0.113.77/28 ", want: "203.0.113.0/24"},
+		{subnet: "203.0.113.77/16", want: "203.0.0.0/16"},
+		{subnet: "2001:db8:1234:5678::1", want: "2001:db8:1234:5600::/56"},
+		{subnet: "2001:db8::/32", want: "2001:db8::/32"},
+		{subnet: "2001:db8:1234:5678::/64", want: "2001:db8:1234:5600::/56"},
+		{subnet: "example.com", wantErr: true},
+		{subnet: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subnet, func(t *testing.T) {
+			got, err := ManualSubnet(tt.subnet)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ManualSubnet(%q) = %q, want error", tt.subnet, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ManualSubnet(%q) = %q, %v, want %q", tt.subnet, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := Truncate(net.ParseIP("::ffff:198.51.100.9")); got != "198.51.100.0/24" {
+		t.Errorf("Truncate(v4-mapped) = %q", got)
+	}
+}
@@ -1,55 +1,151 @@
 package dnsController
 
 import (
-	"github.com/imroc/req/v3"
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"idv-login-go/config"
 	"idv-login-go/constants"
+	"idv-login-go/logger"
+	"sync"
+	"time"
 )
 
 type DnsController struct {
-	dnsHost string
-	params  map[string]string
-	client  *req.Client
+	host  string
+	specs []string
+	opts  ResolverOptions
 }
 
 var conf *config.Config
+var log *logrus.Logger
 
-func NewDnsController() *DnsController {
+// 重启时复用解析缓存与公网IP
+var (
+	once  sync.Once
+	cache *Cache
+
+	discoveryMu  sync.Mutex
+	discovery    *Discovery
+	discoveryKey string // 创建 discovery 时的配置，配置变化后重新创建
+)
+
+// NewDnsController host 为需要解析的主机名
+func NewDnsController(host string) (*DnsController, error) {
 	conf = config.GetConfig()
-	dC := &DnsController{
-		dnsHost: conf.String("hostDNS"),
-		params: map[string]string{
-			"name":               conf.String("host"),
-			"short":              "true",
-			"edns_client_subnet": "",
+	log = logger.GetLogger()
+
+	once.Do(func() {
+		cache = NewCache(constants.DnsCachePath)
+	})
+
+	d := &DnsController{
+		host:  host,
+		specs: providerSpecs(),
+		opts: ResolverOptions{
+			Strategy: conf.String("dnsStrategy"),
+			Timeout:  time.Duration(conf.Int("dnsTimeout")) * time.Second,
+			IPv6:     conf.Bool("dnsIPv6"),
 		},
-		client: req.C(),
 	}
-	// 获取本地IP
-	var ips struct {
-		Ip string `json:"ip"`
+	// 先检查配置，ECS子网在缓存未命中、需要查询时才获取
+	if _, err := NewResolver(d.specs, d.opts); err != nil {
+		return nil, err
 	}
-	resp, _ := dC.client.R().SetQueryParam("type", "0").
-		SetSuccessResult(&ips).
-		Get(constants.IpHost)
+	return d, nil
+}
+
+// DefaultProviders dnsProviders 留空时使用的解析服务
+var DefaultProviders = []string{"json+https://dns.alidns.com/resolve", "https://doh.pub/dns-query", "udp://223.5.5.5:53"}
 
-	if resp.IsSuccessState() {
-		dC.params["edns_client_subnet"] = ips.Ip
+// defaultHostDNS 旧版本 hostDNS 的默认值
+const defaultHostDNS = "https://dns.alidns.com/resolve"
+
+// providerSpecs 配置的解析服务，旧版本只有 hostDNS，修改过时继续使用
+func providerSpecs() []string {
+	if specs := conf.Strings("dnsProviders"); len(specs) > 0 {
+		return specs
+	}
+	if hostDNS := conf.String("hostDNS"); hostDNS != "" && hostDNS != defaultHostDNS {
+		return []string{"json+" + hostDNS}
+	}
+	return DefaultProviders
+}
+
+// Resolve 解析目标主机，成功时至少包含一条记录
+// 优先使用未过期的缓存，解析失败时使用磁盘上最近一次成功的结果
+func (d *DnsController) Resolve() (*Result, error) {
+	if result, ok := cache.Get(d.host); ok {
+		log.Debugf("%s 使用缓存的解析结果：%v", d.host, result.IPs())
+		resolutions.Inc(d.host, "cache")
+		return result, nil
 	}
 
-	return dC
+	opts := d.opts
+	opts.ClientSubnet = clientSubnet()
+	resolver, err := NewResolver(d.specs, opts)
+	if err != nil {
+		return nil, err
+	}
+	result, err := resolver.Resolve(context.Background(), d.host)
+	if err == nil {
+		log.Debugf("%s 解析结果来自 %s：%v", d.host, result.Provider, result.IPs())
+		cache.Put(result)
+		resolutions.Inc(d.host, result.Provider)
+		return result, nil
+	}
+
+	if last, updatedAt, ok := cache.LastGood(d.host); ok {
+		log.Warnf("解析失败：%v\n使用 %s 保存的解析结果：%v", err, updatedAt.Format(time.DateTime), last.IPs())
+		resolutions.Inc(d.host, "last_good")
+		return last, nil
+	}
+	resolutions.Inc(d.host, "failed")
+	return nil, err
 }
 
-func (d *DnsController) Resolve() (string, error) {
-	var ips []string
-	err := d.client.Get(d.dnsHost).
-		SetQueryParams(d.params).
-		Do().
-		Into(&ips)
+// clientSubnet 按配置获取ECS子网，获取失败时不发送ECS
+func clientSubnet() string {
+	switch conf.String("ecsMode") {
+	case EcsOff:
+		return ""
+	case EcsManual:
+		subnet, err := ManualSubnet(conf.String("ecsSubnet"))
+		if err != nil {
+			log.Warnf("%v，不发送ECS", err)
+			return ""
+		}
+		return subnet
+	}
 
+	d, err := getDiscovery()
+	if err != nil {
+		log.Warnf("初始化公网IP来源失败：%v，不发送ECS", err)
+		return ""
+	}
+	subnet, err := d.Subnet(context.Background())
 	if err != nil {
-		return "", err
+		log.Warnf("获取公网IP失败：%v，不发送ECS", err)
+		return ""
 	}
+	return subnet
+}
+
+// getDiscovery 按配置创建或复用公网IP来源，保留已获取的结果
+func getDiscovery() (*Discovery, error) {
+	sources := conf.Strings("ecsSources")
+	timeout := time.Duration(conf.Int("ecsTimeout")) * time.Second
+	key := fmt.Sprintf("%v|%v", sources, timeout)
 
-	return ips[0], nil
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+	if discovery != nil && discoveryKey == key {
+		return discovery, nil
+	}
+	d, err := NewDiscovery(sources, timeout)
+	if err != nil {
+		return nil, err
+	}
+	discovery, discoveryKey = d, key
+	return d, nil
 }
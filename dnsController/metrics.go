@@ -0,0 +1,10 @@
+package dnsController
+
+import "idv-login-go/metrics"
+
+var (
+	queryDuration = metrics.NewHistogram("idv_dns_query_duration_seconds",
+		"解析服务单次查询（A与AAAA并发）的耗时（秒），result 为 ok 或 error", nil, "provider", "result")
+	resolutions = metrics.NewCounter("idv_dns_resolutions_total",
+		"解析上游主机名的次数，source 为给出结果的解析服务，cache 为内存缓存，last_good 为磁盘上保存的结果，failed 为失败", "host", "source")
+)
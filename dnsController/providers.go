@@ -0,0 +1,274 @@
+package dnsController
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/imroc/req/v3"
+	"golang.org/x/net/dns/dnsmessage"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonProvider JSON API 形式的DoH，如 AliDNS 的 /resolve 与 Google 的 /resolve
+type jsonProvider struct {
+	url          string
+	clientSubnet string
+	client       *req.Client
+}
+
+func newJSONProvider(url string, clientSubnet string) *jsonProvider {
+	return &jsonProvider{url: url, clientSubnet: clientSubnet, client: req.C()}
+}
+
+func (p *jsonProvider) Name() string {
+	return "json+" + p.url
+}
+
+func (p *jsonProvider) Query(ctx context.Context, host string, qtype dnsmessage.Type) ([]Record, error) {
+	var answer struct {
+		Status int `json:"Status"`
+		Answer []struct {
+			Type int    `json:"type"`
+			TTL  int    `json:"TTL"`
+			Data string `json:"data"`
+		} `json:"Answer"`
+	}
+	params := map[string]string{
+		"name": host,
+		"type": strconv.Itoa(int(qtype)),
+	}
+	if p.clientSubnet != "" {
+		params["edns_client_subnet"] = p.clientSubnet
+	}
+	resp, err := p.client.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/dns-json").
+		SetQueryParams(params).
+		SetSuccessResult(&answer).
+		Get(p.url)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	rcode := dnsmessage.RCode(answer.Status)
+	if rcode == dnsmessage.RCodeNameError {
+		return nil, nil
+	}
+	if rcode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("解析失败：%s", rcode)
+	}
+
+	var records []Record
+	for _, a := range answer.Answer {
+		if dnsmessage.Type(a.Type) != qtype {
+			continue
+		}
+		ip := net.ParseIP(a.Data)
+		if ip == nil {
+			continue
+		}
+		records = append(records, Record{IP: ip, TTL: time.Duration(a.TTL) * time.Second})
+	}
+	return records, nil
+}
+
+// wireProvider RFC 8484 DoH，使用 GET 与 application/dns-message
+type wireProvider struct {
+	url          string
+	clientSubnet string
+	client       *req.Client
+}
+
+func newWireProvider(url string, clientSubnet string) *wireProvider {
+	return &wireProvider{url: url, clientSubnet: clientSubnet, client: req.C()}
+}
+
+func (p *wireProvider) Name() string {
+	return p.url
+}
+
+func (p *wireProvider) Query(ctx context.Context, host string, qtype dnsmessage.Type) ([]Record, error) {
+	// RFC 8484 建议ID为0以便缓存
+	query, err := buildQuery(0, host, qtype, p.clientSubnet)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/dns-message").
+		SetQueryParam("dns", base64.RawURLEncoding.EncodeToString(query)).
+		Get(p.url)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	body, err := resp.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	return parseRecords(body, 0, qtype)
+}
+
+// plainProvider 普通DNS
+type plainProvider struct {
+	network      string
+	addr         string
+	clientSubnet string
+}
+
+func (p *plainProvider) Name() string {
+	return p.network + "://" + p.addr
+}
+
+func (p *plainProvider) Query(ctx context.Context, host string, qtype dnsmessage.Type) ([]Record, error) {
+	id := uint16(rand.Uint32())
+	query, err := buildQuery(id, host, qtype, p.clientSubnet)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchange(ctx, p.addr, query, p.network)
+	if err == nil && p.network == "udp" && truncated(resp) {
+		resp, err = exchange(ctx, p.addr, query, "tcp")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRecords(resp, id, qtype)
+}
+
+// buildQuery 构造查询报文，clientSubnet 不为空时附带 EDNS Client Subnet
+func buildQuery(id uint16, host string, qtype dnsmessage.Type, clientSubnet string) ([]byte, error) {
+	name, err := dnsmessage.NewName(dnsName(host))
+	if err != nil {
+		return nil, err
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	var options []dnsmessage.Option
+	if clientSubnet != "" {
+		option, err := subnetOption(clientSubnet)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{Options: options}); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+// subnetOption EDNS Client Subnet（RFC 7871），未指定前缀时IPv4取/24，IPv6取/56
+func subnetOption(subnet string) (dnsmessage.Option, error) {
+	var ip net.IP
+	var prefix int
+	if strings.Contains(subnet, "/") {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return dnsmessage.Option{}, err
+		}
+		ip = ipNet.IP
+		prefix, _ = ipNet.Mask.Size()
+	} else {
+		ip = net.ParseIP(subnet)
+		if ip == nil {
+			return dnsmessage.Option{}, fmt.Errorf("无效的子网 %q", subnet)
+		}
+		prefix = 56
+		if ip.To4() != nil {
+			prefix = 24
+		}
+	}
+
+	family := uint16(2)
+	if ip4 := ip.To4(); ip4 != nil {
+		family, ip = 1, ip4
+	}
+	ip = ip.Mask(net.CIDRMask(prefix, len(ip)*8))
+	data := make([]byte, 4, 4+(prefix+7)/8)
+	binary.BigEndian.PutUint16(data, family)
+	data[2] = byte(prefix)
+	data = append(data, ip[:(prefix+7)/8]...)
+	return dnsmessage.Option{Code: 8, Data: data}, nil
+}
+
+// parseRecords 解析应答中的A或AAAA记录，不存在的域名返回空
+func parseRecords(msg []byte, id uint16, qtype dnsmessage.Type) ([]Record, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	if header.ID != id {
+		return nil, fmt.Errorf("应答ID不一致")
+	}
+	if header.RCode == dnsmessage.RCodeNameError {
+		return nil, nil
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("解析失败：%s", header.RCode)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for {
+		rh, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ttl := time.Duration(rh.TTL) * time.Second
+		switch {
+		case rh.Type == dnsmessage.TypeA && qtype == dnsmessage.TypeA:
+			r, err := parser.AResource()
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, Record{IP: net.IP(r.A[:]), TTL: ttl})
+		case rh.Type == dnsmessage.TypeAAAA && qtype == dnsmessage.TypeAAAA:
+			r, err := parser.AAAAResource()
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, Record{IP: net.IP(r.AAAA[:]), TTL: ttl})
+		default:
+			// CNAME等其他记录
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// dnsName 转换为以点结尾的完整域名
+func dnsName(host string) string {
+	host = normalizeName(host)
+	return host + "."
+}
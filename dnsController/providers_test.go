@@ -0,0 +1,155 @@
+package dnsController
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSubnetOption(t *testing.T) {
+	tests := []struct {
+		subnet  string
+		want    []byte
+		wantErr bool
+	}{
+		{subnet: "203.0.113.0/24", want: []byte{0, 1, 24, 0, 203, 0, 113}},
+		{subnet: "203.0.113.77", want: []byte{0, 1, 24, 0, 203, 0, 113}},
+		{subnet: "203.0.113.77/20", want: []byte{0, 1, 20, 0, 203, 0, 112}},
+		{subnet: "10.0.0.0/8", want: []byte{0, 1, 8, 0, 10}},
+		{subnet: "0.0.0.0/0", want: []byte{0, 1, 0, 0}},
+		{subnet: "2001:db8:1234:5678::1", want: []byte{0, 2, 56, 0, 0x20, 0x01, 0x0d, 0xb8, 0x12, 0x34, 0x56}},
+		{subnet: "2001:db8::/32", want: []byte{0, 2, 32, 0, 0x20, 0x01, 0x0d, 0xb8}},
+		{subnet: "example.com", wantErr: true},
+		{subnet: "203.0.113.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subnet, func(t *testing.T) {
+			got, err := subnetOption(tt.subnet)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("subnetOption(%q) = %v, want error", tt.subnet, got)
+				}
+				return
+			}
+			if err != nil || got.Code != 8 || !bytes.Equal(got.Data, tt.want) {
+				t.Errorf("subnetOption(%q) = %d %v, %v, want %v", tt.subnet, got.Code, got.Data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	msg, err := buildQuery(7, "Service.MKey.163.com", dnsmessage.TypeA, "203.0.113.77")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed dnsmessage.Message
+	if err := parsed.Unpack(msg); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != 7 || !parsed.RecursionDesired || len(parsed.Questions) != 1 {
+		t.Fatalf("header = %+v, questions = %v", parsed.Header, parsed.Questions)
+	}
+	if q := parsed.Questions[0]; q.Name.String() != "service.mkey.163.com." || q.Type != dnsmessage.TypeA {
+		t.Errorf("question = %v", q)
+	}
+	if len(parsed.Additionals) != 1 {
+		t.Fatalf("additionals = %v", parsed.Additionals)
+	}
+	opt, ok := parsed.Additionals[0].Body.(*dnsmessage.OPTResource)
+	if !ok || len(opt.Options) != 1 || !bytes.Equal(opt.Options[0].Data, []byte{0, 1, 24, 0, 203, 0, 113}) {
+		t.Errorf("ECS option = %+v", parsed.Additionals[0].Body)
+	}
+
+	if _, err := buildQuery(7, "example.com", dnsmessage.TypeA, "bad"); err == nil {
+		t.Error("invalid subnet should fail")
+	}
+}
+
+// dnsResponse 构造应答，answers 中 net.IP 按长度生成A或AAAA记录，string 生成CNAME
+func dnsResponse(t *testing.T, id uint16, rcode dnsmessage.RCode, answers ...any) []byte {
+	t.Helper()
+	name := dnsmessage.MustNewName("service.mkey.163.com.")
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, RCode: rcode})
+	if err := builder.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.StartAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	for i, answer := range answers {
+		header := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: uint32(60 * (i + 1))}
+		var err error
+		switch v := answer.(type) {
+		case net.IP:
+			if ip4 := v.To4(); ip4 != nil {
+				var r dnsmessage.AResource
+				copy(r.A[:], ip4)
+				err = builder.AResource(header, r)
+			} else {
+				var r dnsmessage.AAAAResource
+				copy(r.AAAA[:], v)
+				err = builder.AAAAResource(header, r)
+			}
+		case string:
+			err = builder.CNAMEResource(header, dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(v)})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestParseRecords(t *testing.T) {
+	ipv4 := net.ParseIP("203.0.113.7")
+	ipv6 := net.ParseIP("2001:db8::1")
+	answers := []any{"cdn.example.com.", ipv4, ipv6}
+	tests := []struct {
+		name    string
+		msg     []byte
+		qtype   dnsmessage.Type
+		want    []Record
+		wantErr bool
+	}{
+		{name: "a", msg: dnsResponse(t, 1, dnsmessage.RCodeSuccess, answers...), qtype: dnsmessage.TypeA,
+			want: []Record{{IP: ipv4, TTL: 120 * time.Second}}},
+		{name: "aaaa", msg: dnsResponse(t, 1, dnsmessage.RCodeSuccess, answers...), qtype: dnsmessage.TypeAAAA,
+			want: []Record{{IP: ipv6, TTL: 180 * time.Second}}},
+		{name: "empty", msg: dnsResponse(t, 1, dnsmessage.RCodeSuccess), qtype: dnsmessage.TypeA},
+		{name: "nxdomain", msg: dnsResponse(t, 1, dnsmessage.RCodeNameError), qtype: dnsmessage.TypeA},
+		{name: "servfail", msg: dnsResponse(t, 1, dnsmessage.RCodeServerFailure), qtype: dnsmessage.TypeA, wantErr: true},
+		{name: "other id", msg: dnsResponse(t, 2, dnsmessage.RCodeSuccess, ipv4), qtype: dnsmessage.TypeA, wantErr: true},
+		{name: "truncated", msg: dnsResponse(t, 1, dnsmessage.RCodeSuccess, ipv4)[:40], qtype: dnsmessage.TypeA, wantErr: true},
+		{name: "garbage", msg: []byte{0, 1, 2}, qtype: dnsmessage.TypeA, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRecords(tt.msg, 1, tt.qtype)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want error", got)
+				}
+				return
+			}
+			if err != nil || len(got) != len(tt.want) {
+				t.Fatalf("got %v, %v, want %v", got, err, tt.want)
+			}
+			for i := range got {
+				if !got[i].IP.Equal(tt.want[i].IP) || got[i].TTL != tt.want[i].TTL {
+					t.Errorf("record %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
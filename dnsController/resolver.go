@@ -0,0 +1,242 @@
+package dnsController
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多个解析服务之间的策略
+const (
+	StrategyFailover = "failover" // 按顺序依次尝试
+	StrategyRace     = "race"     // 同时查询，使用最先成功的结果
+)
+
+// defaultTimeout 单个解析服务的默认超时时间
+const defaultTimeout = 3 * time.Second
+
+// Record 一条A或AAAA记录
+type Record struct {
+	IP  net.IP
+	TTL time.Duration
+}
+
+// IsIPv6 是否为AAAA记录
+func (r Record) IsIPv6() bool {
+	return r.IP.To4() == nil
+}
+
+// Result 解析结果
+type Result struct {
+	Host     string
+	Provider string // 给出结果的解析服务
+	Records  []Record
+}
+
+// IPs 全部IP，IPv4在前
+func (r *Result) IPs() []string {
+	ips := make([]string, 0, len(r.Records))
+	for _, record := range r.Records {
+		if !record.IsIPv6() {
+			ips = append(ips, record.IP.String())
+		}
+	}
+	for _, record := range r.Records {
+		if record.IsIPv6() {
+			ips = append(ips, record.IP.String())
+		}
+	}
+	return ips
+}
+
+// First 第一个IP，优先IPv4，没有记录时返回错误
+func (r *Result) First() (string, error) {
+	ips := r.IPs()
+	if len(ips) == 0 {
+		return "", fmt.Errorf("%s 没有解析结果", r.Host)
+	}
+	return ips[0], nil
+}
+
+// MinTTL 最短的TTL，没有记录时返回0
+func (r *Result) MinTTL() time.Duration {
+	var ttl time.Duration
+	for i, record := range r.Records {
+		if i == 0 || record.TTL < ttl {
+			ttl = record.TTL
+		}
+	}
+	return ttl
+}
+
+// Provider 解析服务
+type Provider interface {
+	// Name 名称，用于日志
+	Name() string
+	// Query 查询一种记录类型，返回的记录可以为空
+	Query(ctx context.Context, host string, qtype dnsmessage.Type) ([]Record, error)
+}
+
+// ResolverOptions 解析选项
+type ResolverOptions struct {
+	Strategy     string        // failover/race
+	Timeout      time.Duration // 单个解析服务的超时时间
+	ClientSubnet string        // EDNS Client Subnet，留空不发送
+	IPv6         bool          // 是否同时查询AAAA记录
+}
+
+// Resolver 使用多个解析服务解析主机名
+type Resolver struct {
+	providers []Provider
+	opts      ResolverOptions
+}
+
+// NewResolver specs 为解析服务地址，格式见 NewProvider
+func NewResolver(specs []string, opts ResolverOptions) (*Resolver, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = StrategyFailover
+	case StrategyFailover, StrategyRace:
+	default:
+		return nil, fmt.Errorf("未知的解析策略 %q", opts.Strategy)
+	}
+
+	providers := make([]Provider, 0, len(specs))
+	for _, spec := range specs {
+		provider, err := NewProvider(spec, opts.ClientSubnet)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("没有可用的解析服务")
+	}
+	return &Resolver{providers: providers, opts: opts}, nil
+}
+
+// Resolve 解析主机名，返回的结果至少包含一条记录
+func (r *Resolver) Resolve(ctx context.Context, host string) (*Result, error) {
+	if r.opts.Strategy == StrategyRace {
+		return r.race(ctx, host)
+	}
+	return r.failover(ctx, host)
+}
+
+// failover 按顺序尝试，直到某个解析服务返回结果
+func (r *Resolver) failover(ctx context.Context, host string) (*Result, error) {
+	var errs []error
+	for _, provider := range r.providers {
+		result, err := r.query(ctx, provider, host)
+		if err != nil {
+			log.Warnf("解析服务 %s 失败：%v", provider.Name(), err)
+			errs = append(errs, fmt.Errorf("%s：%w", provider.Name(), err))
+			continue
+		}
+		return result, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// race 同时查询全部解析服务，使用最先成功的结果
+func (r *Resolver) race(ctx context.Context, host string) (*Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		result *Result
+		err    error
+	}
+	answers := make(chan answer, len(r.providers))
+	for _, provider := range r.providers {
+		go func(provider Provider) {
+			result, err := r.query(ctx, provider, host)
+			if err != nil {
+				err = fmt.Errorf("%s：%w", provider.Name(), err)
+			}
+			answers <- answer{result, err}
+		}(provider)
+	}
+
+	var errs []error
+	for range r.providers {
+		a := <-answers
+		if a.err == nil {
+			return a.result, nil
+		}
+		errs = append(errs, a.err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// query 使用一个解析服务查询A与AAAA记录，超时按单个解析服务计算
+func (r *Resolver) query(ctx context.Context, provider Provider, host string) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+	start := time.Now()
+
+	qtypes := []dnsmessage.Type{dnsmessage.TypeA}
+	if r.opts.IPv6 {
+		qtypes = append(qtypes, dnsmessage.TypeAAAA)
+	}
+	records := make([][]Record, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype dnsmessage.Type) {
+			defer wg.Done()
+			records[i], errs[i] = provider.Query(ctx, host, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	result := &Result{Host: host, Provider: provider.Name()}
+	for _, list := range records {
+		result.Records = append(result.Records, list...)
+	}
+	outcome := "error"
+	if len(result.Records) > 0 {
+		outcome = "ok"
+	}
+	queryDuration.Observe(time.Since(start).Seconds(), provider.Name(), outcome)
+	if len(result.Records) > 0 {
+		// AAAA失败不影响A记录
+		return result, nil
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s 没有解析结果", host)
+}
+
+// NewProvider 按地址创建解析服务：
+//
+//	https://dns.alidns.com/dns-query       RFC 8484 DoH（application/dns-message）
+//	json+https://dns.alidns.com/resolve    JSON API DoH
+//	udp://223.5.5.5:53 或 223.5.5.5        普通DNS（UDP，被截断时改用TCP）
+//	tcp://223.5.5.5:53                     普通DNS（TCP）
+func NewProvider(spec string, clientSubnet string) (Provider, error) {
+	spec = strings.TrimSpace(spec)
+	scheme, rest, ok := strings.Cut(spec, "://")
+	if !ok {
+		return &plainProvider{network: "udp", addr: spec, clientSubnet: clientSubnet}, nil
+	}
+	switch strings.ToLower(scheme) {
+	case "https":
+		return newWireProvider(spec, clientSubnet), nil
+	case "json+https":
+		return newJSONProvider("https://"+rest, clientSubnet), nil
+	case "udp", "tcp":
+		return &plainProvider{network: scheme, addr: rest, clientSubnet: clientSubnet}, nil
+	}
+	return nil, fmt.Errorf("不支持的解析服务 %q", spec)
+}
@@ -0,0 +1,327 @@
+package dnsController
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"idv-login-go/constants"
+	"idv-login-go/logger"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// interceptTTL 被拦截主机名的TTL，较短以便停止后尽快恢复
+const interceptTTL = 10
+
+// forwardTimeout 单个上游的超时时间
+const forwardTimeout = 3 * time.Second
+
+// DnsServer 本地DNS服务器，被拦截的主机名解析到127.0.0.1，其余查询转发到上游
+type DnsServer struct {
+	addr      string
+	hosts     []string
+	upstreams []string
+
+	mu      sync.Mutex
+	udpConn net.PacketConn
+	tcpLn   net.Listener
+	wg      sync.WaitGroup
+}
+
+// NewDnsServer hosts 为拦截的主机名，支持 *.example.com；upstreams 为上游DNS地址
+func NewDnsServer(addr string, hosts []string, upstreams []string) *DnsServer {
+	log = logger.GetLogger()
+	list := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = normalizeName(host); host != "" {
+			list = append(list, host)
+		}
+	}
+	return &DnsServer{
+		addr:      addr,
+		hosts:     list,
+		upstreams: upstreams,
+	}
+}
+
+// Start 同时在UDP与TCP上监听
+func (s *DnsServer) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.udpConn != nil {
+		return errors.New("DNS服务器已启动")
+	}
+
+	udpConn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("监听UDP %s 失败：%w", s.addr, err)
+	}
+	tcpLn, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("监听TCP %s 失败：%w", s.addr, err)
+	}
+	s.udpConn = udpConn
+	s.tcpLn = tcpLn
+
+	s.wg.Add(2)
+	go s.serveUDP(udpConn)
+	go s.serveTCP(tcpLn)
+	log.Infof("DNS服务器已启动：%s，拦截 %s", s.addr, strings.Join(s.hosts, ", "))
+	return nil
+}
+
+// Stop 关闭监听并等待处理中的查询结束
+func (s *DnsServer) Stop() {
+	s.mu.Lock()
+	if s.udpConn == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.udpConn.Close()
+	s.tcpLn.Close()
+	s.udpConn, s.tcpLn = nil, nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	log.Info("DNS服务器已关闭")
+}
+
+// Resolver 使用本地DNS服务器解析的Resolver，用于检查拦截是否生效
+func (s *DnsServer) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.addr)
+		},
+	}
+}
+
+func (s *DnsServer) serveUDP(conn net.PacketConn) {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("读取DNS查询失败：%v", err)
+			}
+			return
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			resp, err := s.handle(query, "udp")
+			if err != nil {
+				log.Debugf("处理DNS查询失败：%v", err)
+				return
+			}
+			conn.WriteTo(resp, addr)
+		}()
+	}
+}
+
+func (s *DnsServer) serveTCP(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("接受DNS连接失败：%v", err)
+			}
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp, err := s.handle(query, "tcp")
+				if err != nil {
+					log.Debugf("处理DNS查询失败：%v", err)
+					return
+				}
+				if err := writeTCPMessage(conn, resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle 拦截的主机名直接应答，其余转发到上游
+func (s *DnsServer) handle(query []byte, network string) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	name := normalizeName(question.Name.String())
+	if question.Class == dnsmessage.ClassINET && s.intercepted(name) {
+		log.Debugf("拦截DNS查询：%s %s", name, question.Type)
+		return s.answer(header, question, dnsmessage.RCodeSuccess)
+	}
+	resp, err := s.forward(query, network)
+	if err != nil {
+		// 上游全部失败时返回SERVFAIL，避免客户端一直等待
+		log.Warnf("转发DNS查询 %s 失败：%v", name, err)
+		return s.answer(header, question, dnsmessage.RCodeServerFailure)
+	}
+	return resp, nil
+}
+
+// intercepted 主机名是否需要拦截
+func (s *DnsServer) intercepted(name string) bool {
+	for _, host := range s.hosts {
+		if host == name {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(host, "*"); ok && strings.HasSuffix(name, suffix) &&
+			!strings.Contains(strings.TrimSuffix(name, suffix), ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// answer 构造应答，A记录返回127.0.0.1，其他类型返回空应答，避免客户端走IPv6绕过代理
+// rcode 不是 RCodeSuccess 时只返回错误码
+func (s *DnsServer) answer(header dnsmessage.Header, question dnsmessage.Question, rcode dnsmessage.RCode) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      rcode == dnsmessage.RCodeSuccess,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if rcode == dnsmessage.RCodeSuccess && question.Type == dnsmessage.TypeA {
+		resource := dnsmessage.AResource{}
+		copy(resource.A[:], net.ParseIP(constants.Localhost).To4())
+		err := builder.AResource(dnsmessage.ResourceHeader{
+			Name:  question.Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   interceptTTL,
+		}, resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// forward 依次尝试上游，UDP应答被截断时改用TCP
+func (s *DnsServer) forward(query []byte, network string) ([]byte, error) {
+	if len(s.upstreams) == 0 {
+		return nil, errors.New("没有可用的上游DNS")
+	}
+	var errs []error
+	for _, upstream := range s.upstreams {
+		ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+		resp, err := exchange(ctx, upstream, query, network)
+		if err == nil && network == "udp" && truncated(resp) {
+			resp, err = exchange(ctx, upstream, query, "tcp")
+		}
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s：%w", upstream, err))
+			continue
+		}
+		return resp, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// exchange 向上游发送一次查询，超时由ctx决定
+func exchange(ctx context.Context, upstream string, query []byte, network string) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// truncated 应答是否设置了TC位
+func truncated(msg []byte) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(msg)
+	return err == nil && header.Truncated
+}
+
+// readTCPMessage 读取带两字节长度前缀的DNS消息
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage 写入带两字节长度前缀的DNS消息
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
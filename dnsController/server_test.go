@@ -0,0 +1,86 @@
+package dnsController
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestIntercepted(t *testing.T) {
+	s := NewDnsServer("127.0.0.1:0", []string{"Service.MKey.163.com.", "*.nie.netease.com", " "}, nil)
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "service.mkey.163.com", want: true},
+		{name: "mkey.163.com", want: false},
+		{name: "a.service.mkey.163.com", want: false},
+		{name: "update.nie.netease.com", want: true},
+		{name: "nie.netease.com", want: false},
+		{name: "a.b.nie.netease.com", want: false},
+		{name: "xnie.netease.com", want: false},
+		{name: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := s.intercepted(tt.name); got != tt.want {
+			t.Errorf("intercepted(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleIntercepted(t *testing.T) {
+	s := NewDnsServer("127.0.0.1:0", []string{"service.mkey.163.com"}, nil)
+	tests := []struct {
+		qtype   dnsmessage.Type
+		answers int
+	}{
+		{qtype: dnsmessage.TypeA, answers: 1},
+		{qtype: dnsmessage.TypeAAAA, answers: 0}, // 不返回IPv6地址，避免绕过代理
+	}
+	for _, tt := range tests {
+		query, err := buildQuery(42, "SERVICE.mkey.163.com", tt.qtype, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := s.handle(query, "udp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(resp); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != 42 || !msg.Response || !msg.Authoritative || msg.RCode != dnsmessage.RCodeSuccess {
+			t.Errorf("%s header = %+v", tt.qtype, msg.Header)
+		}
+		if len(msg.Answers) != tt.answers {
+			t.Fatalf("%s answers = %v", tt.qtype, msg.Answers)
+		}
+		if tt.answers > 0 {
+			a, ok := msg.Answers[0].Body.(*dnsmessage.AResource)
+			if !ok || !net.IP(a.A[:]).Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Errorf("answer = %v", msg.Answers[0].Body)
+			}
+		}
+	}
+}
+
+func TestHandleNoUpstream(t *testing.T) {
+	s := NewDnsServer("127.0.0.1:0", []string{"service.mkey.163.com"}, nil)
+	query, err := buildQuery(43, "example.com", dnsmessage.TypeA, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.handle(query, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 43 || msg.RCode != dnsmessage.RCodeServerFailure || len(msg.Answers) != 0 {
+		t.Errorf("header = %+v, answers = %v", msg.Header, msg.Answers)
+	}
+}
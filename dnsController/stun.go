@@ -0,0 +1,127 @@
+package dnsController
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// STUN（RFC 5389）常量
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunMappedAddress   = 0x0001
+	stunXorMappedAddr   = 0x0020
+)
+
+// stunDiscoverer 通过STUN绑定请求获取公网IP
+type stunDiscoverer struct {
+	server string
+}
+
+func (d *stunDiscoverer) Name() string {
+	return "stun:" + d.server
+}
+
+func (d *stunDiscoverer) Discover(ctx context.Context) (net.IP, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", d.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseStunResponse(buf[:n], request[8:20])
+}
+
+// parseStunResponse 从绑定响应中取出映射地址，优先使用 XOR-MAPPED-ADDRESS
+func parseStunResponse(msg []byte, transactionID []byte) (net.IP, error) {
+	if len(msg) < 20 || binary.BigEndian.Uint16(msg[0:]) != stunBindingResponse {
+		return nil, errors.New("不是STUN绑定响应")
+	}
+	if binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie || !bytes.Equal(msg[8:20], transactionID) {
+		return nil, errors.New("STUN事务ID不一致")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return nil, errors.New("STUN响应不完整")
+	}
+
+	var mapped net.IP
+	attrs := msg[20 : 20+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunXorMappedAddr:
+			if ip := stunAddress(value, msg[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			mapped = stunAddress(value, nil)
+		}
+		// 属性按4字节对齐，最后一个属性可能没有填充
+		skip := 4 + (attrLen+3)/4*4
+		if skip > len(attrs) {
+			break
+		}
+		attrs = attrs[skip:]
+	}
+	if mapped == nil {
+		return nil, errors.New("STUN响应中没有映射地址")
+	}
+	return mapped, nil
+}
+
+// stunAddress 解析地址属性，xor 为魔数与事务ID，为空时表示未做异或
+func stunAddress(value []byte, xor []byte) net.IP {
+	if len(value) < 4 {
+		return nil
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	for i := range ip {
+		if xor != nil {
+			ip[i] ^= xor[i]
+		}
+	}
+	return ip
+}
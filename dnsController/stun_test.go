@@ -0,0 +1,172 @@
+package dnsController
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+var testTransactionID = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+// stunAttr 一个属性，len 不为0时覆盖长度字段
+type stunAttr struct {
+	typ   uint16
+	value []byte
+	len   int
+	noPad bool // 不按4字节填充，模拟被截断的最后一个属性
+}
+
+// stunMessage 构造绑定响应，length 不小于0时覆盖消息长度字段
+func stunMessage(msgType uint16, id []byte, length int, attrs ...stunAttr) []byte {
+	var body []byte
+	for _, a := range attrs {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint16(header, a.typ)
+		attrLen := len(a.value)
+		if a.len != 0 {
+			attrLen = a.len
+		}
+		binary.BigEndian.PutUint16(header[2:], uint16(attrLen))
+		body = append(body, header...)
+		body = append(body, a.value...)
+		if !a.noPad {
+			for len(body)%4 != 0 {
+				body = append(body, 0)
+			}
+		}
+	}
+	msg := make([]byte, 20, 20+len(body))
+	binary.BigEndian.PutUint16(msg, msgType)
+	if length < 0 {
+		length = len(body)
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(length))
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], id)
+	return append(msg, body...)
+}
+
+// mappedValue 地址属性的值，xor 时按魔数与事务ID异或
+func mappedValue(ip net.IP, xor bool) []byte {
+	family := byte(0x02)
+	if ip4 := ip.To4(); ip4 != nil {
+		family, ip = 0x01, ip4
+	}
+	value := append([]byte{0, family, 0x12, 0x34}, ip...)
+	if xor {
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint32(key, stunMagicCookie)
+		copy(key[4:], testTransactionID)
+		for i := range ip {
+			value[4+i] ^= key[i]
+		}
+	}
+	return value
+}
+
+func TestParseStunResponse(t *testing.T) {
+	ipv4 := net.ParseIP("203.0.113.7")
+	ipv6 := net.ParseIP("2001:db8::1234")
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    net.IP
+		wantErr bool
+	}{
+		{
+			name: "xor ipv4",
+			msg:  stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true)}),
+			want: ipv4,
+		},
+		{
+			name: "xor ipv6",
+			msg:  stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv6, true)}),
+			want: ipv6,
+		},
+		{
+			name: "mapped only",
+			msg:  stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: stunMappedAddress, value: mappedValue(ipv4, false)}),
+			want: ipv4,
+		},
+		{
+			name: "xor preferred",
+			msg: stunMessage(stunBindingResponse, testTransactionID, -1,
+				stunAttr{typ: stunMappedAddress, value: mappedValue(net.ParseIP("198.51.100.1"), false)},
+				stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true)}),
+			want: ipv4,
+		},
+		{
+			name: "skips padded attributes",
+			msg: stunMessage(stunBindingResponse, testTransactionID, -1,
+				stunAttr{typ: 0x8022, value: []byte("stun5")}, // SOFTWARE，长度5需要填充
+				stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true)}),
+			want: ipv4,
+		},
+		{
+			name: "unpadded last attribute",
+			msg: stunMessage(stunBindingResponse, testTransactionID, -1,
+				stunAttr{typ: stunMappedAddress, value: mappedValue(ipv4, false)},
+				stunAttr{typ: 0x8022, value: []byte("stun5"), noPad: true}),
+			want: ipv4,
+		},
+		{
+			name:    "unpadded last attribute without address",
+			msg:     stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: 0x8022, value: []byte("stun5"), noPad: true}),
+			wantErr: true,
+		},
+		{
+			name:    "attribute longer than message",
+			msg:     stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true), len: 200}),
+			wantErr: true,
+		},
+		{
+			name:    "unknown family",
+			msg:     stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: stunXorMappedAddr, value: []byte{0, 9, 0, 0, 1, 2, 3, 4}}),
+			wantErr: true,
+		},
+		{
+			name:    "short address",
+			msg:     stunMessage(stunBindingResponse, testTransactionID, -1, stunAttr{typ: stunXorMappedAddr, value: []byte{0, 1, 0, 0, 1}}),
+			wantErr: true,
+		},
+		{
+			name:    "length beyond packet",
+			msg:     stunMessage(stunBindingResponse, testTransactionID, 100, stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true)}),
+			wantErr: true,
+		},
+		{
+			name:    "not a binding response",
+			msg:     stunMessage(stunBindingRequest, testTransactionID, -1, stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true)}),
+			wantErr: true,
+		},
+		{
+			name:    "other transaction",
+			msg:     stunMessage(stunBindingResponse, make([]byte, 12), -1, stunAttr{typ: stunXorMappedAddr, value: mappedValue(ipv4, true)}),
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			msg:     []byte{0x01, 0x01, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "no attributes",
+			msg:     stunMessage(stunBindingResponse, testTransactionID, -1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStunResponse(tt.msg, testTransactionID)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want error", got)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
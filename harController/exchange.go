@@ -0,0 +1,273 @@
+package harController
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Exchange 一次代理：客户端请求、改写后的上游请求、上游响应与最终返回客户端的响应
+type Exchange struct {
+	rec   *Recorder
+	id    int64
+	start time.Time
+
+	// 客户端请求
+	method string
+	url    *url.URL
+	proto  string
+	header http.Header
+	body   *body
+
+	mu       sync.Mutex
+	upstream *upstreamRecord
+}
+
+// upstreamRecord 本程序与上游之间的一次请求
+type upstreamRecord struct {
+	start     time.Time
+	method    string
+	url       *url.URL
+	proto     string
+	header    http.Header
+	body      *body
+	serverIP  string
+	sent      time.Time // 请求发出
+	firstByte time.Time // 收到响应头
+	rspStatus int
+	rspProto  string
+	rspHeader http.Header
+	rspBody   *limitedBuffer
+	err       error
+}
+
+// Begin 开始记录一次代理，body 为客户端发送的原始请求体
+func (r *Recorder) Begin(req *http.Request, reqBody []byte) *Exchange {
+	u := *req.URL
+	u.Scheme = "https"
+	u.Host = req.Host
+	return &Exchange{
+		rec:    r,
+		id:     r.nextID.Add(1),
+		start:  time.Now(),
+		method: req.Method,
+		url:    &u,
+		proto:  req.Proto,
+		header: req.Header.Clone(),
+		body:   r.limit(reqBody),
+	}
+}
+
+func (r *Recorder) limit(data []byte) *body {
+	b := &body{data: data, size: len(data)}
+	if len(data) > r.opts.BodyLimit {
+		b.data = data[:r.opts.BodyLimit]
+		b.truncated = true
+	}
+	return b
+}
+
+// Transport 包装上游的 RoundTripper，记录改写后的请求与上游的原始响应
+func (x *Exchange) Transport(base http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		rec := &upstreamRecord{
+			start:  time.Now(),
+			method: req.Method,
+			url:    req.URL,
+			proto:  req.Proto,
+			header: req.Header.Clone(),
+			body:   &body{},
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			data, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			rec.body = x.rec.limit(data)
+			req.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		x.mu.Lock()
+		x.upstream = rec
+		x.mu.Unlock()
+
+		ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				ip, _, _ := net.SplitHostPort(info.Conn.RemoteAddr().String())
+				x.mu.Lock()
+				rec.serverIP = ip
+				x.mu.Unlock()
+			},
+			WroteRequest: func(httptrace.WroteRequestInfo) {
+				x.mu.Lock()
+				rec.sent = time.Now()
+				x.mu.Unlock()
+			},
+		})
+		rsp, err := base.RoundTrip(req.WithContext(ctx))
+
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		rec.firstByte = time.Now()
+		if err != nil {
+			rec.err = err
+			return nil, err
+		}
+		rec.rspStatus = rsp.StatusCode
+		rec.rspProto = rsp.Proto
+		rec.rspHeader = rsp.Header.Clone()
+		rec.rspBody = &limitedBuffer{limit: x.rec.opts.BodyLimit}
+		rsp.Body = &teeBody{ReadCloser: rsp.Body, buf: rec.rspBody}
+		return rsp, nil
+	})
+}
+
+// ResponseWriter 包装返回客户端的 ResponseWriter，记录最终的响应
+func (x *Exchange) ResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, buf: &limitedBuffer{limit: x.rec.opts.BodyLimit}}
+}
+
+// Finish 写入客户端与上游两条记录
+func (x *Exchange) Finish(w *ResponseWriter) {
+	end := time.Now()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	client := &Entry{
+		StartedDateTime: x.start.Format(time.RFC3339Nano),
+		Request:         newRequest(x.method, x.url, x.proto, x.header, x.body),
+		Response:        newResponse(w.Status(), x.proto, w.Header(), w.buf.body()),
+		Exchange:        x.id,
+		Side:            SideClient,
+	}
+	// 客户端记录：等待时间为收到请求到写出响应头，其余为发送响应体
+	wait := w.headerAt.Sub(x.start)
+	if w.headerAt.IsZero() {
+		wait = end.Sub(x.start)
+	}
+	client.Timings = Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: millis(wait), Receive: millis(end.Sub(x.start) - wait)}
+	client.Time = client.Timings.Wait + client.Timings.Receive
+	entries := []*Entry{client}
+
+	if rec := x.upstream; rec != nil {
+		upstream := &Entry{
+			StartedDateTime: rec.start.Format(time.RFC3339Nano),
+			Request:         newRequest(rec.method, rec.url, rec.proto, rec.header, rec.body),
+			ServerIPAddress: rec.serverIP,
+			Exchange:        x.id,
+			Side:            SideUpstream,
+		}
+		if rec.err != nil {
+			upstream.Response = newResponse(0, "", http.Header{}, &body{})
+			upstream.Comment = rec.err.Error()
+		} else {
+			upstream.Response = newResponse(rec.rspStatus, rec.rspProto, rec.rspHeader, rec.rspBody.body())
+		}
+		// 上游记录：阻塞为选择IP与建立连接，发送到收到响应头为等待，其余为读取响应体
+		sent := rec.sent
+		if sent.IsZero() {
+			sent = rec.firstByte
+		}
+		upstream.Timings = Timings{
+			Blocked: millis(sent.Sub(rec.start)),
+			DNS:     -1,
+			Connect: -1,
+			SSL:     -1,
+			Wait:    millis(rec.firstByte.Sub(sent)),
+			Receive: millis(end.Sub(rec.firstByte)),
+		}
+		upstream.Time = upstream.Timings.Blocked + upstream.Timings.Wait + upstream.Timings.Receive
+		entries = append(entries, upstream)
+	}
+	x.rec.write(entries...)
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// limitedBuffer 记录前 limit 个字节，并统计总长度
+type limitedBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   bytes.Buffer
+	size  int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size += len(p)
+	if remain := b.limit - b.buf.Len(); remain > 0 {
+		if len(p) > remain {
+			b.buf.Write(p[:remain])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) body() *body {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &body{data: bytes.Clone(b.buf.Bytes()), size: b.size, truncated: b.size > b.buf.Len()}
+}
+
+// teeBody 读取上游响应体时同时记录
+type teeBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// ResponseWriter 写出响应时同时记录
+type ResponseWriter struct {
+	http.ResponseWriter
+	buf      *limitedBuffer
+	status   int
+	headerAt time.Time
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.headerAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.buf.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap 供 http.ResponseController 使用
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status 写出的状态码
+func (w *ResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
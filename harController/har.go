@@ -0,0 +1,226 @@
+package harController
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR 1.2 格式，见 http://www.softwareishard.com/blog/har-12-spec/
+// 以下划线开头的字段为自定义字段
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type PostData struct {
+	MimeType string      `json:"mimeType"`
+	Params   []NameValue `json:"params"`
+	Text     string      `json:"text"`
+	Encoding string      `json:"_encoding,omitempty"` // 非文本请求体为 base64
+	Comment  string      `json:"comment,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// Timings 各阶段耗时（毫秒），不适用时为 -1
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type Entry struct {
+	StartedDateTime string   `json:"startedDateTime"`
+	Time            float64  `json:"time"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+	ServerIPAddress string   `json:"serverIPAddress,omitempty"`
+	Comment         string   `json:"comment,omitempty"`
+	Exchange        int64    `json:"_exchange"` // 同一次代理的客户端与上游两条记录编号相同
+	Side            string   `json:"_side"`     // client 为客户端与本程序之间，upstream 为本程序与上游之间
+}
+
+// 记录的一侧
+const (
+	SideClient   = "client"
+	SideUpstream = "upstream"
+)
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func headers(h http.Header) []NameValue {
+	list := make([]NameValue, 0, len(h))
+	for name, values := range h {
+		for _, value := range values {
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func queryString(u *url.URL) []NameValue {
+	list := make([]NameValue, 0)
+	for name, values := range u.Query() {
+		for _, value := range values {
+			list = append(list, NameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func requestCookies(h http.Header) []Cookie {
+	list := make([]Cookie, 0)
+	for _, c := range (&http.Request{Header: h}).Cookies() {
+		list = append(list, Cookie{Name: c.Name, Value: c.Value})
+	}
+	return list
+}
+
+func responseCookies(h http.Header) []Cookie {
+	list := make([]Cookie, 0)
+	for _, c := range (&http.Response{Header: h}).Cookies() {
+		cookie := Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.Format(time.RFC3339)
+		}
+		list = append(list, cookie)
+	}
+	return list
+}
+
+// body 截断后的消息体，文本原样保存，其余使用 base64
+type body struct {
+	data      []byte
+	size      int // 完整长度
+	truncated bool
+}
+
+func (b *body) text() (string, string) {
+	if utf8.Valid(b.data) {
+		return string(b.data), ""
+	}
+	// 截断位置可能在多字节字符中间
+	if b.truncated {
+		for cut := 1; cut < utf8.UTFMax && cut < len(b.data); cut++ {
+			if data := b.data[:len(b.data)-cut]; utf8.Valid(data) {
+				return string(data), ""
+			}
+		}
+	}
+	return base64.StdEncoding.EncodeToString(b.data), "base64"
+}
+
+func (b *body) comment() string {
+	if b.truncated {
+		return "内容已截断"
+	}
+	return ""
+}
+
+func newRequest(method string, u *url.URL, proto string, h http.Header, b *body) Request {
+	req := Request{
+		Method:      method,
+		URL:         u.String(),
+		HTTPVersion: proto,
+		Cookies:     requestCookies(h),
+		Headers:     headers(h),
+		QueryString: queryString(u),
+		HeadersSize: -1,
+		BodySize:    b.size,
+	}
+	if b.size > 0 {
+		text, encoding := b.text()
+		mimeType := h.Get("Content-Type")
+		post := &PostData{MimeType: mimeType, Params: make([]NameValue, 0), Text: text, Encoding: encoding, Comment: b.comment()}
+		if mediaType, _, _ := mime.ParseMediaType(mimeType); mediaType == "application/x-www-form-urlencoded" && !b.truncated {
+			if values, err := url.ParseQuery(string(b.data)); err == nil {
+				for name, list := range values {
+					for _, value := range list {
+						post.Params = append(post.Params, NameValue{Name: name, Value: value})
+					}
+				}
+			}
+		}
+		req.PostData = post
+	}
+	return req
+}
+
+func newResponse(status int, proto string, h http.Header, b *body) Response {
+	text, encoding := b.text()
+	return Response{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: proto,
+		Cookies:     responseCookies(h),
+		Headers:     headers(h),
+		Content: Content{
+			Size:     b.size,
+			MimeType: h.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  b.comment(),
+		},
+		RedirectURL: h.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    b.size,
+	}
+}
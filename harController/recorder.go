@@ -0,0 +1,179 @@
+package harController
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"idv-login-go/logger"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options 记录选项
+type Options struct {
+	Dir       string // 保存目录
+	MaxBytes  int64  // 目录中HAR文件的总大小上限，超出时删除最旧的文件
+	FileBytes int64  // 单个文件的大小上限，超出时换新文件
+	BodyLimit int    // 每个消息体最多记录的字节数
+}
+
+// Recorder 将一次运行（会话）的代理记录写入HAR文件
+// 文件在每条记录写入后都是完整的HAR，程序被结束时也不会损坏
+type Recorder struct {
+	opts    Options
+	session string
+	nextID  atomic.Int64
+
+	mu      sync.Mutex
+	file    *os.File
+	part    int
+	size    int64
+	entries int
+	closed  bool
+}
+
+const harTrailer = "]}}\n"
+
+// FilePattern 本程序写入的HAR文件名，清理时只删除匹配的文件
+const FilePattern = "session-*.har"
+
+var log *logrus.Logger
+
+// NewRecorder 开始新的会话
+func NewRecorder(opts Options) (*Recorder, error) {
+	log = logger.GetLogger()
+	if opts.FileBytes <= 0 {
+		opts.FileBytes = 5 << 20
+	}
+	if opts.MaxBytes < opts.FileBytes {
+		opts.MaxBytes = opts.FileBytes
+	}
+	if opts.BodyLimit <= 0 {
+		opts.BodyLimit = 1 << 20
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		opts:    opts,
+		session: "session-" + time.Now().Format("20060102-150405"),
+	}
+	log.Infof("HAR记录已开启，保存到 %s", opts.Dir)
+	return r, nil
+}
+
+// Close 关闭当前文件
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// write 追加记录，覆盖掉末尾的结束符后重新写入
+func (r *Recorder) write(entries ...*Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	var datas [][]byte
+	var size int64
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("序列化HAR记录失败：%v", err)
+			return
+		}
+		datas = append(datas, data)
+		size += int64(len(data)) + 1
+	}
+	if r.file == nil || r.entries > 0 && r.size+size > r.opts.FileBytes {
+		if err := r.rotate(); err != nil {
+			log.Errorf("创建HAR文件失败：%v", err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, data := range datas {
+		if i > 0 || r.entries > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(data)
+	}
+	buf.WriteString(harTrailer)
+
+	offset := r.size - int64(len(harTrailer))
+	if _, err := r.file.WriteAt(buf.Bytes(), offset); err != nil {
+		log.Errorf("写入HAR文件失败：%v", err)
+		return
+	}
+	r.size = offset + int64(buf.Len())
+	r.entries += len(entries)
+}
+
+// rotate 换新文件并清理超出总大小的旧文件
+func (r *Recorder) rotate() error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	r.part++
+	name := filepath.Join(r.opts.Dir, fmt.Sprintf("%s-%03d.har", r.session, r.part))
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	creator, _ := json.Marshal(Creator{Name: "idv-login-go", Version: "1.0"})
+	header := `{"log":{"version":"1.2","creator":` + string(creator) + `,"entries":[`
+	if _, err := file.WriteString(header + harTrailer); err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = int64(len(header) + len(harTrailer))
+	r.entries = 0
+	r.prune(name)
+	return nil
+}
+
+// prune 按文件名（即时间）从旧到新删除，直到总大小不超过上限，不删除当前文件
+func (r *Recorder) prune(current string) {
+	names, err := filepath.Glob(filepath.Join(r.opts.Dir, FilePattern))
+	if err != nil {
+		return
+	}
+	sort.Strings(names)
+	var total int64
+	sizes := make(map[string]int64, len(names))
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil {
+			sizes[name] = info.Size()
+			total += info.Size()
+		}
+	}
+	// 为当前文件预留空间
+	total += r.opts.FileBytes - sizes[current]
+	for _, name := range names {
+		if total <= r.opts.MaxBytes || name == current {
+			break
+		}
+		if err := os.Remove(name); err != nil {
+			log.Warnf("删除旧的HAR文件失败：%v", err)
+			continue
+		}
+		total -= sizes[name]
+		log.Debugf("已删除旧的HAR文件 %s", name)
+	}
+}
@@ -0,0 +1,201 @@
+package harController
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+// upstreamFunc 模拟上游
+type upstreamFunc func(req *http.Request) *http.Response
+
+func (f upstreamFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+func textResponse(req *http.Request, status int, contentType string, body string) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", contentType)
+	return &http.Response{
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// record 模拟一次代理：客户端请求经改写后发往上游，上游响应改写后返回客户端
+func record(t *testing.T, r *Recorder, clientBody string, upstreamBody string, clientReply string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "https://service.mkey.163.com/mpay/api/login?a=1", strings.NewReader(clientBody))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	x := r.Begin(req, []byte(clientBody))
+
+	upReq, err := http.NewRequest(http.MethodPost, "https://service.mkey.163.com/mpay/api/login?a=1", strings.NewReader(clientBody+"&cv=c3.15.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	transport := x.Transport(upstreamFunc(func(req *http.Request) *http.Response {
+		return textResponse(req, http.StatusOK, "application/json", upstreamBody)
+	}))
+	rsp, err := transport.RoundTrip(upReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rsp.Body); err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+
+	w := x.ResponseWriter(httptest.NewRecorder())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(clientReply))
+	x.Finish(w)
+}
+
+func readHAR(t *testing.T, fn string) *HAR {
+	t.Helper()
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har HAR
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("%s is not valid HAR: %v\n%s", fn, err, data)
+	}
+	return &har
+}
+
+func harFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "session-*.har"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		record(t, r, "username=a", `{"code":0}`, `{"code":0,"user":"a"}`)
+		// 每次写入后文件都应是完整的HAR
+		files := harFiles(t, dir)
+		if len(files) != 1 {
+			t.Fatalf("got %d files, want 1", len(files))
+		}
+		if got := len(readHAR(t, files[0]).Log.Entries); got != 2*(i+1) {
+			t.Fatalf("got %d entries after %d exchanges, want %d", got, i+1, 2*(i+1))
+		}
+	}
+
+	entries := readHAR(t, harFiles(t, dir)[0]).Log.Entries
+	client, upstream := entries[0], entries[1]
+	if client.Side != SideClient || upstream.Side != SideUpstream {
+		t.Errorf("sides = %q, %q", client.Side, upstream.Side)
+	}
+	if client.Exchange != upstream.Exchange || entries[2].Exchange == client.Exchange {
+		t.Errorf("exchange ids = %d, %d, %d", client.Exchange, upstream.Exchange, entries[2].Exchange)
+	}
+	if client.Request.PostData == nil || client.Request.PostData.Text != "username=a" {
+		t.Errorf("client request body = %+v", client.Request.PostData)
+	}
+	if upstream.Request.PostData == nil || upstream.Request.PostData.Text != "username=a&cv=c3.15.0" {
+		t.Errorf("upstream request body = %+v", upstream.Request.PostData)
+	}
+	if got := upstream.Response.Content.Text; got != `{"code":0}` {
+		t.Errorf("upstream response body = %q", got)
+	}
+	if got := client.Response.Content.Text; got != `{"code":0,"user":"a"}` {
+		t.Errorf("client response body = %q", got)
+	}
+	if got := client.Request.URL; got != "https://service.mkey.163.com/mpay/api/login?a=1" {
+		t.Errorf("client request url = %q", got)
+	}
+}
+
+func TestRecorderBodyLimit(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(Options{Dir: dir, BodyLimit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	record(t, r, "username=a", "0123456789", "abcdefgh")
+
+	entries := readHAR(t, harFiles(t, dir)[0]).Log.Entries
+	client, upstream := entries[0], entries[1]
+	if post := client.Request.PostData; post.Text != "user" || post.Comment == "" || client.Request.BodySize != 10 {
+		t.Errorf("client request body = %+v, size %d", post, client.Request.BodySize)
+	}
+	if len(client.Request.PostData.Params) != 0 {
+		t.Errorf("truncated form body should not be parsed: %+v", client.Request.PostData.Params)
+	}
+	if c := upstream.Response.Content; c.Text != "0123" || c.Size != 10 || c.Comment == "" {
+		t.Errorf("upstream response content = %+v", c)
+	}
+	if c := client.Response.Content; c.Text != "abcd" || c.Size != 8 {
+		t.Errorf("client response content = %+v", c)
+	}
+}
+
+func TestRecorderRotate(t *testing.T) {
+	dir := t.TempDir()
+	// 旧会话的文件，总大小超出上限时应先被删除
+	old := filepath.Join(dir, "session-00000000-000000-001.har")
+	if err := os.WriteFile(old, bytes.Repeat([]byte{' '}, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecorder(Options{Dir: dir, FileBytes: 2048, MaxBytes: 6144})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	body := strings.Repeat("x", 300) // 一次代理的两条记录小于 FileBytes，单个文件不会超出上限
+	for i := 0; i < 12; i++ {
+		record(t, r, "username=a", body, body)
+	}
+
+	files := harFiles(t, dir)
+	if len(files) < 2 {
+		t.Fatalf("got %d files, want rotation", len(files))
+	}
+	var total int64
+	for _, fn := range files {
+		if fn == old {
+			t.Errorf("old session file was not pruned")
+			continue
+		}
+		info, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+		// 每个文件单独是完整的HAR，客户端与上游记录不会被拆到两个文件
+		if n := len(readHAR(t, fn).Log.Entries); n == 0 || n%2 != 0 {
+			t.Errorf("%s has %d entries", fn, n)
+		}
+	}
+	if total > 6144 {
+		t.Errorf("total size %d exceeds MaxBytes", total)
+	}
+}
@@ -0,0 +1,278 @@
+package harController
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/goccy/go-json"
+	"idv-login-go/logger"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 未匹配请求的处理方式
+const (
+	MatchStrict      = "strict"      // 方法、主机名、路径、参数与请求体都要一致，否则返回错误
+	MatchLoose       = "loose"       // 完整匹配失败时只按方法、主机名与路径匹配
+	MatchPassthrough = "passthrough" // 同 loose，仍未匹配时请求真实上游
+)
+
+// HAR HAR文件
+type HAR struct {
+	Log struct {
+		Version string   `json:"version"`
+		Creator Creator  `json:"creator"`
+		Entries []*Entry `json:"entries"`
+	} `json:"log"`
+}
+
+// ReplayOptions 回放选项
+type ReplayOptions struct {
+	Match        string   // 未匹配请求的处理方式
+	IgnoreParams []string // 匹配时忽略的参数，如时间戳、签名，对url参数、表单与JSON请求体的顶层字段生效
+}
+
+// MissError 没有匹配的记录
+type MissError struct {
+	Method string
+	URL    string
+}
+
+func (e *MissError) Error() string {
+	return fmt.Sprintf("回放记录中没有 %s %s", e.Method, e.URL)
+}
+
+// Replay 按记录的上游响应回放，代替真实上游
+type Replay struct {
+	opts   ReplayOptions
+	ignore map[string]bool
+
+	mu    sync.Mutex
+	exact map[string]*replayQueue // 完整匹配
+	loose map[string]*replayQueue // 只按方法、主机名与路径匹配
+	count int
+}
+
+// replayQueue 相同请求的多条记录按顺序返回，用完后重复最后一条
+type replayQueue struct {
+	entries []*Entry
+	next    int
+}
+
+func (q *replayQueue) pop() *Entry {
+	e := q.entries[q.next]
+	if q.next < len(q.entries)-1 {
+		q.next++
+	}
+	return e
+}
+
+// NewReplay 从HAR文件加载记录，本程序记录的文件只使用上游一侧，其他工具导出的文件使用全部记录
+func NewReplay(files []string, opts ReplayOptions) (*Replay, error) {
+	log = logger.GetLogger()
+	switch opts.Match {
+	case "":
+		opts.Match = MatchStrict
+	case MatchStrict, MatchLoose, MatchPassthrough:
+	default:
+		return nil, fmt.Errorf("未知的回放匹配方式 %q", opts.Match)
+	}
+	r := &Replay{
+		opts:   opts,
+		ignore: make(map[string]bool),
+		exact:  make(map[string]*replayQueue),
+		loose:  make(map[string]*replayQueue),
+	}
+	for _, name := range opts.IgnoreParams {
+		r.ignore[name] = true
+	}
+	for _, file := range files {
+		if err := r.load(file); err != nil {
+			return nil, fmt.Errorf("加载回放文件 %s 失败：%w", file, err)
+		}
+	}
+	if r.count == 0 {
+		return nil, fmt.Errorf("回放文件中没有可用的记录")
+	}
+	log.Infof("已加载 %d 条回放记录，匹配方式：%s", r.count, opts.Match)
+	return r, nil
+}
+
+func (r *Replay) load(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var har HAR
+	if err := json.Unmarshal(data, &har); err != nil {
+		return err
+	}
+	for _, e := range har.Log.Entries {
+		if e.Side == SideClient || e.Response.Status == 0 {
+			continue
+		}
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			log.Warnf("跳过无效的回放记录 %s：%v", e.Request.URL, err)
+			continue
+		}
+		if e.Response.Content.Comment != "" {
+			log.Warnf("回放记录 %s %s：%s", e.Request.Method, e.Request.URL, e.Response.Content.Comment)
+		}
+		body, err := postBody(e.Request.PostData)
+		if err != nil {
+			log.Warnf("跳过无效的回放记录 %s：%v", e.Request.URL, err)
+			continue
+		}
+		header := make(http.Header)
+		if e.Request.PostData != nil {
+			header.Set("Content-Type", e.Request.PostData.MimeType)
+		}
+		r.add(r.exact, r.exactKey(e.Request.Method, u, header, body), e)
+		r.add(r.loose, looseKey(e.Request.Method, u), e)
+		r.count++
+	}
+	return nil
+}
+
+func (r *Replay) add(m map[string]*replayQueue, key string, e *Entry) {
+	q, ok := m[key]
+	if !ok {
+		q = &replayQueue{}
+		m[key] = q
+	}
+	q.entries = append(q.entries, e)
+}
+
+// Transport 回放的 RoundTripper，passthrough 时未匹配的请求交给 fallback
+func (r *Replay) Transport(fallback http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		var body []byte
+		if req.Body != nil && req.Body != http.NoBody {
+			var err error
+			body, err = io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if e := r.find(req, body); e != nil {
+			log.Debugf("回放 %s %s", req.Method, req.URL)
+			return replayResponse(req, e)
+		}
+		if r.opts.Match == MatchPassthrough && fallback != nil {
+			log.Infof("回放记录中没有 %s %s，请求上游", req.Method, req.URL)
+			return fallback.RoundTrip(req)
+		}
+		return nil, &MissError{Method: req.Method, URL: req.URL.String()}
+	})
+}
+
+func (r *Replay) find(req *http.Request, body []byte) *Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if q, ok := r.exact[r.exactKey(req.Method, req.URL, req.Header, body)]; ok {
+		return q.pop()
+	}
+	if r.opts.Match == MatchStrict {
+		return nil
+	}
+	if q, ok := r.loose[looseKey(req.Method, req.URL)]; ok {
+		return q.pop()
+	}
+	return nil
+}
+
+func looseKey(method string, u *url.URL) string {
+	return strings.ToUpper(method) + " " + strings.ToLower(u.Hostname()) + u.EscapedPath()
+}
+
+// exactKey 在 looseKey 的基础上加入排序后的url参数与规范化的请求体
+func (r *Replay) exactKey(method string, u *url.URL, h http.Header, body []byte) string {
+	query := u.Query()
+	for name := range r.ignore {
+		query.Del(name)
+	}
+	return looseKey(method, u) + "?" + query.Encode() + "#" + r.normalizeBody(h.Get("Content-Type"), body)
+}
+
+// normalizeBody 表单按键排序，JSON重新序列化（对象按键排序），其余使用摘要
+func (r *Replay) normalizeBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for name := range r.ignore {
+				values.Del(name)
+			}
+			return values.Encode()
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err == nil {
+			if m, ok := v.(map[string]interface{}); ok {
+				for name := range r.ignore {
+					delete(m, name)
+				}
+			}
+			if data, err := json.Marshal(v); err == nil {
+				return string(data)
+			}
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func postBody(post *PostData) ([]byte, error) {
+	if post == nil {
+		return nil, nil
+	}
+	if post.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(post.Text)
+	}
+	return []byte(post.Text), nil
+}
+
+// replayResponse 按记录生成响应，Content-Length 按实际长度重新计算
+func replayResponse(req *http.Request, e *Entry) (*http.Response, error) {
+	content := e.Response.Content
+	data := []byte(content.Text)
+	if content.Encoding == "base64" {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(content.Text); err != nil {
+			return nil, fmt.Errorf("回放记录的响应体无效：%w", err)
+		}
+	}
+	header := make(http.Header)
+	for _, h := range e.Response.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Response.Status, http.StatusText(e.Response.Status)),
+		StatusCode:    e.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
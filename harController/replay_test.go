@@ -0,0 +1,198 @@
+package harController
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+// writeReplayHAR 写入回放文件，entries 为 方法、URL、请求体类型、请求体、响应体
+func writeReplayHAR(t *testing.T, entries [][5]string) string {
+	t.Helper()
+	var har HAR
+	for _, e := range entries {
+		entry := &Entry{
+			Request:  Request{Method: e[0], URL: e[1]},
+			Response: Response{Status: http.StatusOK, Content: Content{Text: e[4]}},
+			Side:     SideUpstream,
+		}
+		if e[3] != "" {
+			entry.Request.PostData = &PostData{MimeType: e[2], Text: e[3]}
+		}
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+	// 本程序记录的客户端一侧不参与回放
+	har.Log.Entries = append(har.Log.Entries, &Entry{
+		Request:  Request{Method: http.MethodGet, URL: "https://service.mkey.163.com/client"},
+		Response: Response{Status: http.StatusOK, Content: Content{Text: "client"}},
+		Side:     SideClient,
+	})
+	data, err := json.Marshal(har)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "replay.har")
+	if err := os.WriteFile(fn, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestReplayMatch(t *testing.T) {
+	file := writeReplayHAR(t, [][5]string{
+		{"GET", "https://service.mkey.163.com/mpay/games/h55/config?b=2&a=1&ts=100", "", "", "config"},
+		{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "user=a&sign=x&ts=100", "login-a"},
+		{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "user=b&sign=x&ts=100", "login-b"},
+		{"POST", "https://service.mkey.163.com/mpay/api/qrcode", "application/json", `{"uuid":"u1","ts":100,"extra":{"k":1}}`, "qrcode"},
+	})
+
+	type request struct {
+		method      string
+		url         string
+		contentType string
+		body        string
+	}
+	const (
+		miss        = "<miss>"
+		passthrough = "<passthrough>"
+	)
+	tests := []struct {
+		name    string
+		match   string
+		ignore  []string
+		request request
+		want    string
+	}{
+		{"strict exact", MatchStrict, nil, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?a=1&b=2&ts=100", "", ""}, "config"},
+		{"strict query order", MatchStrict, nil, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?ts=100&b=2&a=1", "", ""}, "config"},
+		{"strict host case", MatchStrict, nil, request{"GET", "https://SERVICE.mkey.163.com/mpay/games/h55/config?a=1&b=2&ts=100", "", ""}, "config"},
+		{"strict changed param", MatchStrict, nil, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?a=1&b=2&ts=200", "", ""}, miss},
+		{"strict ignored query param", MatchStrict, []string{"ts"}, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?a=1&b=2&ts=200", "", ""}, "config"},
+		{"strict missing ignored param", MatchStrict, []string{"ts"}, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?a=1&b=2", "", ""}, "config"},
+		{"strict form order", MatchStrict, nil, request{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "ts=100&sign=x&user=b"}, "login-b"},
+		{"strict form changed", MatchStrict, nil, request{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "user=b&sign=y&ts=200"}, miss},
+		{"strict ignored form params", MatchStrict, []string{"sign", "ts"}, request{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "user=a&sign=y&ts=200"}, "login-a"},
+		{"strict json key order", MatchStrict, nil, request{"POST", "https://service.mkey.163.com/mpay/api/qrcode", "application/json; charset=utf-8", `{"extra":{"k":1},"ts":100,"uuid":"u1"}`}, "qrcode"},
+		{"strict ignored json field", MatchStrict, []string{"ts"}, request{"POST", "https://service.mkey.163.com/mpay/api/qrcode", "application/json", `{"uuid":"u1","ts":999,"extra":{"k":1}}`}, "qrcode"},
+		{"strict json nested not ignored", MatchStrict, []string{"k"}, request{"POST", "https://service.mkey.163.com/mpay/api/qrcode", "application/json", `{"uuid":"u1","ts":100,"extra":{"k":2}}`}, miss},
+		{"strict method", MatchStrict, nil, request{"POST", "https://service.mkey.163.com/mpay/games/h55/config?a=1&b=2&ts=100", "", ""}, miss},
+		{"strict client side skipped", MatchStrict, nil, request{"GET", "https://service.mkey.163.com/client", "", ""}, miss},
+		{"loose changed params", MatchLoose, nil, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?a=9", "", ""}, "config"},
+		{"loose prefers exact", MatchLoose, nil, request{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "user=b&sign=x&ts=100"}, "login-b"},
+		{"loose falls back in order", MatchLoose, nil, request{"POST", "https://service.mkey.163.com/mpay/api/login", "application/x-www-form-urlencoded", "user=c"}, "login-a"},
+		{"loose unknown path", MatchLoose, nil, request{"GET", "https://service.mkey.163.com/mpay/api/unknown", "", ""}, miss},
+		{"passthrough matched", MatchPassthrough, nil, request{"GET", "https://service.mkey.163.com/mpay/games/h55/config?a=9", "", ""}, "config"},
+		{"passthrough unknown path", MatchPassthrough, nil, request{"GET", "https://service.mkey.163.com/mpay/api/unknown", "", ""}, passthrough},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReplay([]string{file}, ReplayOptions{Match: tt.match, IgnoreParams: tt.ignore})
+			if err != nil {
+				t.Fatal(err)
+			}
+			fallback := upstreamFunc(func(req *http.Request) *http.Response {
+				return textResponse(req, http.StatusOK, "text/plain", passthrough)
+			})
+			var body io.Reader
+			if tt.request.body != "" {
+				body = strings.NewReader(tt.request.body)
+			}
+			req, err := http.NewRequest(tt.request.method, tt.request.url, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.request.contentType != "" {
+				req.Header.Set("Content-Type", tt.request.contentType)
+			}
+
+			rsp, err := r.Transport(fallback).RoundTrip(req)
+			var missErr *MissError
+			if tt.want == miss {
+				if !errors.As(err, &missErr) {
+					t.Fatalf("got %v, want MissError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(rsp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %q, want %q", data, tt.want)
+			}
+			if tt.want != passthrough && rsp.ContentLength != int64(len(data)) {
+				t.Errorf("Content-Length = %d, body is %d bytes", rsp.ContentLength, len(data))
+			}
+		})
+	}
+}
+
+func TestReplaySequence(t *testing.T) {
+	file := writeReplayHAR(t, [][5]string{
+		{"GET", "https://service.mkey.163.com/mpay/api/poll", "", "", "poll-1"},
+		{"GET", "https://service.mkey.163.com/mpay/api/poll", "", "", "poll-2"},
+	})
+	r, err := NewReplay([]string{file}, ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 相同请求按记录顺序返回，用完后重复最后一条
+	for _, want := range []string{"poll-1", "poll-2", "poll-2"} {
+		req, _ := http.NewRequest(http.MethodGet, "https://service.mkey.163.com/mpay/api/poll", nil)
+		rsp, err := r.Transport(nil).RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rsp.Body)
+		if string(data) != want {
+			t.Errorf("got %q, want %q", data, want)
+		}
+	}
+}
+
+func TestReplayOptions(t *testing.T) {
+	file := writeReplayHAR(t, nil)
+	if _, err := NewReplay([]string{file}, ReplayOptions{}); err == nil {
+		t.Error("NewReplay succeeded without upstream entries")
+	}
+	file = writeReplayHAR(t, [][5]string{{"GET", "https://service.mkey.163.com/", "", "", "ok"}})
+	if _, err := NewReplay([]string{file}, ReplayOptions{Match: "fuzzy"}); err == nil {
+		t.Error("NewReplay accepted an unknown match mode")
+	}
+}
+
+// TestReplayRecorded 回放本程序记录的HAR文件
+func TestReplayRecorded(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := NewRecorder(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, rec, "username=a", `{"code":0}`, `{"code":0,"user":"a"}`)
+	rec.Close()
+
+	r, err := NewReplay(harFiles(t, dir), ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 回放的是改写后发往上游的请求与上游的原始响应
+	req, _ := http.NewRequest(http.MethodPost, "https://service.mkey.163.com/mpay/api/login?a=1", strings.NewReader("cv=c3.15.0&username=a"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rsp, err := r.Transport(nil).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rsp.Body)
+	if string(data) != `{"code":0}` || rsp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got %q (%s)", data, rsp.Header.Get("Content-Type"))
+	}
+}
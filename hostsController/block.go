@@ -0,0 +1,98 @@
+package hostsController
+
+import (
+	"bytes"
+	"idv-login-go/constants"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxBackups 保留的hosts备份数量，不含 OriginalBackup
+const maxBackups = 10
+
+// OriginalBackup 第一次写入前的hosts，不会被轮换删除
+var OriginalBackup = filepath.Join(constants.HostsBackupDir, "original.bak")
+
+// Backups 轮换的hosts备份，按时间从旧到新排序
+func Backups() ([]string, error) {
+	backups, err := filepath.Glob(filepath.Join(constants.HostsBackupDir, "hosts-*.bak"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// findBlock 找到管理的区块，返回区块中的记录
+func findBlock(data []byte) ([]string, bool) {
+	var entries []string
+	var inBlock, found bool
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == beginMarker:
+			inBlock, found = true, true
+			entries = nil
+		case line == endMarker:
+			inBlock = false
+		case inBlock && line != "":
+			entries = append(entries, line)
+		}
+	}
+	return entries, found
+}
+
+// stripBlock 移除管理的区块，缺少结束标记时移除到文件末尾
+func stripBlock(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	out := make([]byte, 0, len(data))
+	var inBlock bool
+	for _, line := range lines {
+		trimmed := string(bytes.TrimSpace(line))
+		switch {
+		case trimmed == beginMarker:
+			inBlock = true
+		case trimmed == endMarker && inBlock:
+			inBlock = false
+		case !inBlock:
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// stripLegacy 移除区块外旧版本不带标记写入的记录，只移除与 entry 完全相同的行
+func stripLegacy(data []byte, entry string) []byte {
+	if entry == "" {
+		return data
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	out := make([]byte, 0, len(data))
+	var inBlock bool
+	for _, line := range lines {
+		trimmed := string(bytes.TrimSpace(line))
+		switch {
+		case trimmed == beginMarker:
+			inBlock = true
+		case trimmed == endMarker:
+			inBlock = false
+		case !inBlock && strings.Join(strings.Fields(trimmed), " ") == entry:
+			continue
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+func slicesEqual(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if strings.Join(strings.Fields(a[i]), " ") != strings.Join(strings.Fields(b[i]), " ") {
+			return false
+		}
+	}
+	return true
+}
@@ -1,78 +1,316 @@
 package hostsController
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
 	"github.com/goodhosts/hostsfile"
 	"github.com/sirupsen/logrus"
 	"idv-login-go/config"
 	"idv-login-go/constants"
 	"idv-login-go/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 本程序管理的hosts区块标记，区块外的内容不会被修改
+const (
+	beginMarker = "# BEGIN idv-login-go"
+	endMarker   = "# END idv-login-go"
 )
 
 type HostsController struct {
-	hosts *hostsfile.Hosts
+	path    string
+	entries []string
+	legacy  string // 旧版本不带区块标记写入的记录
+}
+
+// journal 写入hosts后记录，正常移除时删除；启动时存在说明上次未正常退出
+type journal struct {
+	Path    string    `json:"path"`
+	Backup  string    `json:"backup"`
+	Entries []string  `json:"entries"`
+	Pid     int       `json:"pid"`
+	Time    time.Time `json:"time"`
 }
 
 var log *logrus.Logger
 var conf *config.Config
 var localhost = constants.Localhost
-var host string
 
 func New() *HostsController {
 	log = logger.GetLogger()
 	conf = config.GetConfig()
-	host = conf.String("host")
 
-	hosts, _ := hostsfile.NewHosts()
-	return &HostsController{hosts: hosts}
+	path := os.ExpandEnv(filepath.FromSlash(hostsfile.HostsFilePath))
+	if env, ok := os.LookupEnv("HOSTS_PATH"); ok && env != "" {
+		path = os.ExpandEnv(filepath.FromSlash(env))
+	}
+	var entries []string
+	for _, host := range conf.Hosts() {
+		entries = append(entries, localhost+" "+host)
+	}
+	// 旧版本只写入 host 一条记录，即第一条
+	var legacy string
+	if len(entries) > 0 {
+		legacy = entries[0]
+	}
+	return &HostsController{
+		path:    path,
+		entries: entries,
+		legacy:  legacy,
+	}
 }
 
+// Exist 管理的区块是否存在且与当前配置一致
 func (h *HostsController) Exist() bool {
-	return h.hosts.Has(localhost, host)
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return false
+	}
+	block, ok := findBlock(data)
+	return ok && slicesEqual(block, h.entries)
 }
 
+// Managed 是否存在管理的区块，不论内容是否与当前配置一致
+func (h *HostsController) Managed() bool {
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return false
+	}
+	_, ok := findBlock(data)
+	return ok
+}
+
+// Add 写入管理的区块，已有的区块与旧版本遗留的记录会被替换
 func (h *HostsController) Add() bool {
 	if !h.IsWritable() {
 		return false
 	}
-	// 添加 hosts
-	err := h.hosts.Add(localhost, host)
+	backup, err := h.write(true, func(data []byte, eol string) []byte {
+		data = stripLegacy(stripBlock(data), h.legacy)
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data, eol...)
+		}
+		block := append([]string{beginMarker}, h.entries...)
+		block = append(block, endMarker)
+		return append(data, strings.Join(block, eol)+eol...)
+	})
 	if err != nil {
 		log.Errorf("添加 hosts 失败：%v", err)
 		return false
 	}
 
-	err = h.hosts.Flush()
-	if err != nil {
-		log.Errorf("保存 hosts 失败：%v", err)
-		return false
+	// 记录日志，崩溃后下次启动时据此还原
+	if err := h.writeJournal(backup); err != nil {
+		log.Warnf("写入 hosts 日志失败：%v", err)
 	}
 	return true
 }
 
+// Remove 移除管理的区块与旧版本遗留的记录，修改前备份
 func (h *HostsController) Remove() bool {
 	if !h.IsWritable() {
 		return false
 	}
-	// 移除
-	err := h.hosts.Remove(localhost, host)
-	if err != nil {
+	if _, err := h.write(true, func(data []byte, eol string) []byte {
+		return stripLegacy(stripBlock(data), h.legacy)
+	}); err != nil {
 		log.Errorf("移除 hosts 失败：%v", err)
 		return false
 	}
+	if err := os.Remove(constants.HostsJournalPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnf("删除 hosts 日志失败：%v", err)
+	}
+	return true
+}
 
-	err = h.hosts.Flush()
+// Recover 上次运行未正常移除hosts时（崩溃或被结束）移除遗留的区块，并移除旧版本不带标记的记录
+// 写入记录的进程仍在运行时不做修改并返回错误，避免移除正在运行的实例的记录
+// 日志损坏时无法确定写入区块的进程，保留区块
+func (h *HostsController) Recover() error {
+	data, err := os.ReadFile(constants.HostsJournalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		h.migrateLegacy()
+		return nil
+	}
+	var j journal
+	if err == nil {
+		err = json.Unmarshal(data, &j)
+	}
 	if err != nil {
-		log.Errorf("保存 hosts 失败：%v", err)
-		return false
+		log.Warnf("读取 hosts 日志失败，无法确定写入的实例，保留 hosts 记录：%v", err)
+		h.migrateLegacy()
+		return nil
 	}
-	return true
+	if name, ok := runningInstance(j.Pid); ok {
+		return fmt.Errorf("另一个实例 %s（PID %d）正在运行，请先退出该实例", name, j.Pid)
+	}
+	log.Warnf("上次运行（PID %d，%s）未正常退出，移除遗留的 hosts 记录", j.Pid, j.Time.Format(time.DateTime))
+	if h.Remove() {
+		log.Info("遗留的 hosts 记录已移除")
+	}
+	return nil
+}
+
+// migrateLegacy 移除旧版本不带区块标记写入的记录，不存在时不修改hosts
+func (h *HostsController) migrateLegacy() {
+	backup, err := h.write(true, func(data []byte, eol string) []byte {
+		return stripLegacy(data, h.legacy)
+	})
+	if err != nil {
+		log.Warnf("移除旧版本的 hosts 记录失败：%v", err)
+		return
+	}
+	if backup != "" {
+		log.Infof("已移除旧版本的 hosts 记录 %s，备份：%s", h.legacy, backup)
+	}
+}
+
+// Restore 从备份还原hosts，backup 为空时使用最新的备份，为 original 时使用第一次写入前的备份
+// 备份中的管理区块与旧版本遗留的记录会被移除，还原后不会重新指向本机
+func (h *HostsController) Restore(backup string) error {
+	switch backup {
+	case "":
+		backups, err := Backups()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return errors.New("没有 hosts 备份")
+		}
+		backup = backups[len(backups)-1]
+	case "original":
+		backup = OriginalBackup
+	}
+	content, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+	content = stripLegacy(stripBlock(content), h.legacy)
+	if _, err := h.write(true, func([]byte, string) []byte {
+		return content
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(constants.HostsJournalPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnf("删除 hosts 日志失败：%v", err)
+	}
+	log.Infof("已从 %s 还原 hosts", backup)
+	return nil
 }
 
 func (h *HostsController) IsWritable() bool {
 	// 检查文件是否可写
-	if !h.hosts.IsWritable() {
+	file, err := os.OpenFile(h.path, os.O_WRONLY, 0)
+	if err != nil {
 		log.Errorf("hosts 文件不可写")
 		return false
 	}
+	file.Close()
 	return true
 }
+
+// write 修改hosts，先写入同目录的临时文件再重命名替换；backup 为 true 时先备份，返回备份路径
+// 内容没有变化时不备份也不写入
+func (h *HostsController) write(backup bool, modify func(data []byte, eol string) []byte) (string, error) {
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return "", err
+	}
+
+	eol := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	content := modify(data, eol)
+	if bytes.Equal(content, data) {
+		return "", nil
+	}
+
+	var name string
+	if backup {
+		if name, err = h.backup(data); err != nil {
+			return "", fmt.Errorf("备份 hosts 失败：%w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), ".hosts-idv-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// backup 保存移除管理区块后的内容，备份中不会包含指向本机的记录
+// 第一次备份另存为 OriginalBackup，不会被轮换删除；与最新的备份相同时不再保存，只保留最近的 maxBackups 份
+func (h *HostsController) backup(data []byte) (string, error) {
+	data = stripBlock(data)
+	if err := os.MkdirAll(constants.HostsBackupDir, 0755); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(OriginalBackup); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(OriginalBackup, data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	backups, err := Backups()
+	if err == nil && len(backups) > 0 {
+		latest := backups[len(backups)-1]
+		if old, err := os.ReadFile(latest); err == nil && bytes.Equal(old, data) {
+			return latest, nil
+		}
+	}
+	name := filepath.Join(constants.HostsBackupDir, "hosts-"+time.Now().Format("20060102-150405.000")+".bak")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return "", err
+	}
+
+	backups, err = Backups()
+	if err == nil && len(backups) > maxBackups {
+		for _, old := range backups[:len(backups)-maxBackups] {
+			os.Remove(old)
+		}
+	}
+	return name, nil
+}
+
+func (h *HostsController) writeJournal(backup string) error {
+	data, err := json.MarshalIndent(journal{
+		Path:    h.path,
+		Backup:  backup,
+		Entries: h.entries,
+		Pid:     os.Getpid(),
+		Time:    time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(constants.HostsJournalPath, data, 0644)
+}
@@ -0,0 +1,34 @@
+package hostsController
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runningInstance pid 对应的进程是否为仍在运行的本程序，返回进程名
+// 进程已退出、PID 被其他程序复用或为当前进程时返回 false
+func runningInstance(pid int) (string, bool) {
+	if pid <= 0 || pid == os.Getpid() {
+		return "", false
+	}
+	name, ok := processName(pid)
+	if !ok {
+		return "", false
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return name, true
+	}
+	self := programName(filepath.Base(exe))
+	other := programName(name)
+	// Linux 的 comm 最多15个字符
+	if len(other) == 15 && len(self) > 15 {
+		self = self[:15]
+	}
+	return name, other == self
+}
+
+func programName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".exe")
+}
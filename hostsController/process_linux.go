@@ -0,0 +1,21 @@
+package hostsController
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// processName 进程的可执行文件名，没有权限读取 exe 时使用 comm，进程不存在时返回 false
+func processName(pid int) (string, bool) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+	if exe, err := os.Readlink(dir + "/exe"); err == nil {
+		return filepath.Base(strings.TrimSuffix(exe, " (deleted)")), true
+	}
+	comm, err := os.ReadFile(dir + "/comm")
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(comm)), true
+}
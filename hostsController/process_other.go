@@ -0,0 +1,23 @@
+//go:build !linux && !windows
+
+package hostsController
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processName 使用 ps 查询进程名，进程不存在时返回 false
+func processName(pid int) (string, bool) {
+	output, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=").Output()
+	if err != nil {
+		return "", false
+	}
+	name := strings.TrimSpace(string(output))
+	if name == "" {
+		return "", false
+	}
+	return filepath.Base(name), true
+}
@@ -0,0 +1,22 @@
+package hostsController
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// processName 使用 tasklist 查询进程名，进程不存在或查询失败时返回 false
+func processName(pid int) (string, bool) {
+	output, err := exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", pid), "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return "", false
+	}
+	record, err := csv.NewReader(strings.NewReader(string(output))).Read()
+	if err != nil || len(record) < 2 || record[1] != strconv.Itoa(pid) {
+		return "", false
+	}
+	return record[0], true
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -15,6 +16,17 @@ import (
 var once sync.Once
 var instance *logrus.Logger
 
+// Dir 日志文件目录，为空时只输出到标准输出；需在第一次 GetLogger 前设置
+// go test 中默认为空，测试不会在包目录下创建 log 目录
+var Dir = defaultDir()
+
+func defaultDir() string {
+	if testing.Testing() {
+		return ""
+	}
+	return "log"
+}
+
 // GetLogger 返回配置了自定义设置的记录器的单一实例。
 func GetLogger() *logrus.Logger {
 	once.Do(func() {
@@ -37,23 +49,28 @@ func configureLogger() *logrus.Logger {
 	log := logrus.New()
 	log.SetFormatter(&customFormatter{})
 	log.SetReportCaller(true)
+	log.AddHook(recent)
+	// 默认日志级别为info
+	log.SetLevel(logrus.InfoLevel)
+	if Dir == "" {
+		log.SetOutput(os.Stdout)
+		return log
+	}
+
 	// 创建log目录
-	if _, err := os.Stat("log"); os.IsNotExist(err) {
-		err = os.MkdirAll("log", 0755)
+	if _, err := os.Stat(Dir); os.IsNotExist(err) {
+		err = os.MkdirAll(Dir, 0755)
 		if err != nil {
 			log.Errorf("创建日志目录失败： %v", err)
 		}
 	}
 
 	currentTime := time.Now().Format("2006-01-02 15-04-05")
-	logFile, err := os.OpenFile("log/"+currentTime+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := os.OpenFile(path.Join(Dir, currentTime+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		log.Errorf("无法打开日志文件： %v", err)
 	}
 	mw := io.MultiWriter(os.Stdout, logFile)
 	log.SetOutput(mw)
-
-	// 默认日志级别为info
-	log.SetLevel(logrus.InfoLevel)
 	return log
 }
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recentSize 保留的最近日志条数
+const recentSize = 500
+
+// Line 一条日志
+type Line struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Caller  string    `json:"caller,omitempty"`
+	Message string    `json:"message"`
+}
+
+// recentHook 在内存中保留最近的日志，供管理接口查询
+type recentHook struct {
+	mu    sync.Mutex
+	lines []Line
+	next  int
+	full  bool
+}
+
+var recent = &recentHook{lines: make([]Line, recentSize)}
+
+func (h *recentHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *recentHook) Fire(entry *logrus.Entry) error {
+	line := Line{Time: entry.Time, Level: entry.Level.String(), Message: entry.Message}
+	if entry.Caller != nil {
+		line.Caller = fmt.Sprintf("%s:%d", path.Base(entry.Caller.File), entry.Caller.Line)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines[h.next] = line
+	h.next = (h.next + 1) % len(h.lines)
+	if h.next == 0 {
+		h.full = true
+	}
+	return nil
+}
+
+// Recent 最近的 n 条日志，从旧到新；n 不大于0时返回全部保留的日志
+func Recent(n int) []Line {
+	GetLogger()
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	count := recent.next
+	if recent.full {
+		count = len(recent.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	lines := make([]Line, 0, n)
+	for i := recent.next - n; i < recent.next; i++ {
+		lines = append(lines, recent.lines[(i+len(recent.lines))%len(recent.lines)])
+	}
+	return lines
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/getlantern/elevate"
 	"github.com/sirupsen/logrus"
 	"idv-login-go/config"
+	"idv-login-go/hostsController"
 	"idv-login-go/logger"
-	"idv-login-go/windowController"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 )
@@ -19,18 +21,22 @@ var (
 )
 
 type BootArgs struct {
-	DontAdmin bool
+	DontAdmin    bool
+	Uninstall    bool
+	RestoreHosts string
+	Command      *command // 为空时运行托盘
+	CommandArgs  []string
 }
 
 func main() {
 	// 解析参数
 	args := ParseBootArgs()
-	if !args.DontAdmin && runtime.GOOS != "linux" {
-		cmd := elevate.Command(os.Args[0], "--noadmin")
-		cmd.Start()
-		os.Exit(0)
+	needsAdmin := args.Command == nil || args.Command.needsAdmin(args.CommandArgs) || args.Uninstall || args.RestoreHosts != ""
+	if !args.DontAdmin && needsAdmin && runtime.GOOS != "linux" {
+		// 托盘模式启动后立即退出，命令行操作等待其完成并返回退出码
+		tray := args.Command == nil && !args.Uninstall && args.RestoreHosts == ""
+		os.Exit(runElevated(!tray))
 	}
-	windowController.GetWindowController().HideWindow()
 	// 切换工作目录
 	ex, err := os.Executable()
 	if err != nil {
@@ -49,17 +55,77 @@ func main() {
 	log = logger.GetLogger()
 	conf = config.GetConfig()
 
-	app := newTray()
-	app.run()
+	if args.Uninstall {
+		if report := uninstall(); report.failed() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args.RestoreHosts != "" {
+		backup := args.RestoreHosts
+		if backup == "latest" {
+			backup = ""
+		}
+		if err := hostsController.New().Restore(backup); err != nil {
+			log.Errorf("还原 hosts 失败：%v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if args.Command != nil {
+		os.Exit(args.Command.run(args.CommandArgs))
+	}
+
+	if !runTray() {
+		// 没有托盘时在前台运行
+		os.Exit(cmdRun(nil))
+	}
+}
+
+// runElevated 以管理员权限重新运行本程序，wait 时等待其退出并返回它的退出码
+// 提权后的进程不继承本进程的标准输入输出，输出见日志文件
+func runElevated(wait bool) int {
+	cmd := elevate.Command(os.Args[0], append([]string{"--noadmin"}, os.Args[1:]...)...)
+	if !wait {
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "以管理员权限运行失败：%v\n", err)
+			return 1
+		}
+		return 0
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "以管理员权限运行失败：%v\n", err)
+		return 1
+	}
+	return 0
 }
 
 func ParseBootArgs() *BootArgs {
 	var args BootArgs
 	// 使用flag包解析命令行参数
 	flag.BoolVar(&args.DontAdmin, "noadmin", false, "不要升级权限")
+	flag.BoolVar(&args.Uninstall, "uninstall", false, "移除CA证书、程序生成的文件与hosts记录后退出")
+	flag.StringVar(&args.RestoreHosts, "restore-hosts", "", "从备份还原hosts后退出，latest 表示最新的备份，original 表示第一次写入前的备份")
 
 	// 解析flag
+	flag.Usage = printUsage
 	flag.Parse()
 
+	// 子命令
+	if flag.NArg() > 0 {
+		args.Command = findCommand(flag.Arg(0))
+		if args.Command == nil {
+			fmt.Fprintf(os.Stderr, "未知的命令 %q\n\n", flag.Arg(0))
+			printUsage()
+			os.Exit(2)
+		}
+		args.CommandArgs = flag.Args()[1:]
+	}
 	return &args
 }
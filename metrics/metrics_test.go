@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// reset 清空注册的指标，测试之间互不影响
+func reset(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	old := collectors
+	collectors = make(map[string]collector)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		collectors = old
+		mu.Unlock()
+	})
+}
+
+func write(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWrite(t *testing.T) {
+	reset(t)
+
+	requests := NewCounter("test_requests_total", "请求数\n第二行 \\ 反斜杠", "host", "code")
+	requests.Inc("b.example.com", "200")
+	requests.Inc("a.example.com", "502")
+	requests.Add(2, "a.example.com", "200")
+	requests.Add(-1, "a.example.com", "200") // 计数器不能减少
+	requests.Inc(`quote"back\slash`+"\nnewline", "")
+
+	duration := NewHistogram("test_duration_seconds", "耗时", []float64{1, 0.1, 0.5}, "host")
+	duration.Observe(0.05, "a.example.com")
+	duration.Observe(0.1, "a.example.com") // 等于上界时计入该分桶
+	duration.Observe(0.7, "a.example.com")
+	duration.Observe(3, "a.example.com")
+
+	NewGaugeFunc("test_state", "状态", []string{"state"}, func() []Sample {
+		return []Sample{{Values: []string{"running"}, Value: 1}, {Values: []string{"stopped"}, Value: 0}, {Value: 2}} // 缺少标签值的样本被丢弃
+	})
+	NewGaugeFunc("test_expiry_days", "剩余天数", nil, func() []Sample {
+		return []Sample{{Value: math.Inf(1)}}
+	})
+	NewGaugeFunc("test_empty", "没有样本", nil, func() []Sample {
+		return nil
+	})
+
+	want := `# HELP test_duration_seconds 耗时
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{host="a.example.com",le="0.1"} 2
+test_duration_seconds_bucket{host="a.example.com",le="0.5"} 2
+test_duration_seconds_bucket{host="a.example.com",le="1"} 3
+test_duration_seconds_bucket{host="a.example.com",le="+Inf"} 4
+test_duration_seconds_sum{host="a.example.com"} 3.85
+test_duration_seconds_count{host="a.example.com"} 4
+# HELP test_empty 没有样本
+# TYPE test_empty gauge
+# HELP test_expiry_days 剩余天数
+# TYPE test_expiry_days gauge
+test_expiry_days +Inf
+# HELP test_requests_total 请求数\n第二行 \\ 反斜杠
+# TYPE test_requests_total counter
+test_requests_total{host="a.example.com",code="200"} 2
+test_requests_total{host="a.example.com",code="502"} 1
+test_requests_total{host="b.example.com",code="200"} 1
+test_requests_total{host="quote\"back\\slash\nnewline",code=""} 1
+# HELP test_state 状态
+# TYPE test_state gauge
+test_state{state="running"} 1
+test_state{state="stopped"} 0
+`
+	if got := write(t); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramDefBuckets(t *testing.T) {
+	reset(t)
+	h := NewHistogram("test_seconds", "耗时", nil)
+	h.Observe(0.003)
+	h.Observe(20)
+
+	want := `# HELP test_seconds 耗时
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.005"} 1
+test_seconds_bucket{le="0.01"} 1
+test_seconds_bucket{le="0.025"} 1
+test_seconds_bucket{le="0.05"} 1
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="0.25"} 1
+test_seconds_bucket{le="0.5"} 1
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="2.5"} 1
+test_seconds_bucket{le="5"} 1
+test_seconds_bucket{le="10"} 1
+test_seconds_bucket{le="+Inf"} 2
+test_seconds_sum 20.003
+test_seconds_count 2
+`
+	if got := write(t); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	reset(t)
+	NewCounter("test_total", "旧").Inc()
+	NewCounter("test_total", "新")
+
+	want := "# HELP test_total 新\n# TYPE test_total counter\n"
+	if got := write(t); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	reset(t)
+	c := NewCounter("test_total", "计数", "host", "code")
+	h := NewHistogram("test_seconds", "耗时", nil, "host")
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{name: "counter fewer", fn: func() { c.Inc("a.example.com") }},
+		{name: "counter more", fn: func() { c.Inc("a.example.com", "200", "extra") }},
+		{name: "counter none", fn: func() { c.Add(1) }},
+		{name: "histogram more", fn: func() { h.Observe(1, "a.example.com", "200") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("want panic")
+				}
+			}()
+			tt.fn()
+		})
+	}
+
+	want := "# HELP test_seconds 耗时\n# TYPE test_seconds histogram\n# HELP test_total 计数\n# TYPE test_total counter\n"
+	if got := write(t); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"idv-login-go/constants"
+	"net/http"
+)
+
+// DefaultRules 内置规则，与原先写死在代理服务器中的改写一致
+func DefaultRules() []*Rule {
+	return []*Rule{
+		{
+			// 修改登录方法
+			Name:    "login_methods",
+			Method:  http.MethodGet,
+			Path:    "/mpay/games/:game_id/login_methods",
+			Request: []Operation{cvOperation(constants.Pcv)},
+			Response: []Operation{
+				{Op: OpSet, Path: "select_platform", Value: true},
+				{Op: OpSet, Path: "qrcode_select_platform", Value: true},
+				{Op: OpSet, Path: "config.*.select_platforms", Value: []interface{}{0, 1, 2, 3, 4}},
+			},
+		},
+		// 首次登录
+		cvRule("mobile_login_finish", http.MethodPost, "/mpay/api/users/login/mobile/finish", constants.Icv),
+		cvRule("mobile_login_get_sms", http.MethodPost, "/mpay/api/users/login/mobile/get_sms", constants.Icv),
+		cvRule("mobile_login_verify_sms", http.MethodPost, "/mpay/api/users/login/mobile/verify_sms", constants.Icv),
+		cvRule("device_users", http.MethodPost, "/mpay/games/:game_id/devices/:device_id/users", constants.Icv),
+		{
+			// 登录
+			Name:    "login",
+			Method:  http.MethodGet,
+			Path:    "/mpay/games/:game_id/devices/:device_id/users/:user_id",
+			Request: []Operation{cvOperation(constants.Icv)},
+			Response: []Operation{
+				{Op: OpSet, Path: "user.pc_ext_info", Value: constants.PcInfo},
+			},
+		},
+		{
+			// 更改审核状态
+			Name:    "pc_config",
+			Method:  http.MethodGet,
+			Path:    "/mpay/games/pc_config",
+			Request: []Operation{cvOperation(constants.Icv)},
+			Response: []Operation{
+				{Op: OpSet, Path: "game.config.cv_review_status", Value: 1},
+			},
+		},
+	}
+}
+
+// cvRule 只覆盖cv的规则
+func cvRule(name string, method string, path string, cv string) *Rule {
+	return &Rule{
+		Name:    name,
+		Method:  method,
+		Path:    path,
+		Request: []Operation{cvOperation(cv)},
+	}
+}
+
+// cvOperation 覆盖cv，GET请求写入url参数，POST请求写入表单
+func cvOperation(cv string) Operation {
+	return Operation{Op: OpSet, Target: TargetParam, Path: "cv", Value: cv}
+}
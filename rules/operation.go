@@ -0,0 +1,209 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// modifier 作用于路径末端的值，exists 表示该值原本是否存在，返回新值以及是否保留
+type modifier func(value interface{}, exists bool) (interface{}, bool)
+
+func (o *Operation) validate() error {
+	switch o.Op {
+	case OpSet, OpMerge, OpAppend:
+		if o.Value == nil {
+			return fmt.Errorf("%s 操作缺少 value", o.Op)
+		}
+	case OpDelete:
+		if o.Path == "" {
+			return errors.New("delete 操作缺少 path")
+		}
+	case OpRename:
+		if o.Path == "" || o.To == "" {
+			return errors.New("rename 操作需要 path 与 to")
+		}
+	default:
+		return fmt.Errorf("未知的操作类型 %q", o.Op)
+	}
+	if o.Op == OpMerge {
+		if _, ok := o.Value.(map[string]interface{}); !ok {
+			return errors.New("merge 操作的 value 必须是对象")
+		}
+	}
+	return nil
+}
+
+// apply 对body执行操作，返回新的body
+func (o *Operation) apply(body interface{}) (interface{}, error) {
+	segs := splitPath(o.Path)
+
+	switch o.Op {
+	case OpSet:
+		return modify(body, segs, func(interface{}, bool) (interface{}, bool) {
+			return deepCopy(o.Value), true
+		})
+	case OpDelete:
+		return modify(body, segs, func(v interface{}, exists bool) (interface{}, bool) {
+			return v, false
+		})
+	case OpMerge:
+		return modify(body, segs, func(v interface{}, exists bool) (interface{}, bool) {
+			if dst, ok := v.(map[string]interface{}); ok {
+				mergeMap(dst, o.Value.(map[string]interface{}))
+				return dst, true
+			}
+			return deepCopy(o.Value), true
+		})
+	case OpAppend:
+		return modify(body, segs, func(v interface{}, exists bool) (interface{}, bool) {
+			arr, ok := v.([]interface{})
+			if exists && !ok {
+				return v, true // 不是数组，保持原样
+			}
+			if values, ok := o.Value.([]interface{}); ok {
+				return append(arr, deepCopy(values).([]interface{})...), true
+			}
+			return append(arr, deepCopy(o.Value)), true
+		})
+	case OpRename:
+		if len(segs) == 0 {
+			return body, errors.New("rename 不能作用于根节点")
+		}
+		key := segs[len(segs)-1]
+		return modify(body, segs[:len(segs)-1], func(v interface{}, exists bool) (interface{}, bool) {
+			if m, ok := v.(map[string]interface{}); ok {
+				if old, ok := m[key]; ok {
+					delete(m, key)
+					m[o.To] = old
+				}
+			}
+			return v, exists
+		})
+	}
+	return body, fmt.Errorf("未知的操作类型 %q", o.Op)
+}
+
+// splitPath 拆分JSON路径，允许以 $ 或 $. 开头
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// modify 沿路径递归找到目标节点并交给fn处理，中间节点不存在时不做任何修改
+func modify(node interface{}, segs []string, fn modifier) (interface{}, error) {
+	if len(segs) == 0 {
+		v, _ := fn(node, node != nil)
+		return v, nil
+	}
+	seg, rest := segs[0], segs[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		keys := []string{seg}
+		if seg == "*" {
+			keys = keys[:0]
+			for k := range n {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			v, ok := n[k]
+			if len(rest) == 0 {
+				if nv, keep := fn(v, ok); keep {
+					n[k] = nv
+				} else {
+					delete(n, k)
+				}
+				continue
+			}
+			if !ok {
+				continue
+			}
+			nv, err := modify(v, rest, fn)
+			if err != nil {
+				return node, err
+			}
+			n[k] = nv
+		}
+		return n, nil
+
+	case []interface{}:
+		selected := make(map[int]bool)
+		if seg == "*" {
+			for i := range n {
+				selected[i] = true
+			}
+		} else {
+			i, err := strconv.Atoi(seg)
+			if err != nil {
+				return node, fmt.Errorf("数组下标 %q 无效", seg)
+			}
+			if i < 0 {
+				i += len(n)
+			}
+			if i < 0 || i >= len(n) {
+				return node, nil
+			}
+			selected[i] = true
+		}
+
+		result := n[:0:0]
+		for i, v := range n {
+			if !selected[i] {
+				result = append(result, v)
+				continue
+			}
+			if len(rest) == 0 {
+				if nv, keep := fn(v, true); keep {
+					result = append(result, nv)
+				}
+				continue
+			}
+			nv, err := modify(v, rest, fn)
+			if err != nil {
+				return node, err
+			}
+			result = append(result, nv)
+		}
+		return result, nil
+	}
+	return node, nil
+}
+
+// mergeMap 将src深度合并到dst
+func mergeMap(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				mergeMap(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[k] = deepCopy(v)
+	}
+}
+
+// deepCopy 复制规则中的值，避免多个请求共享同一份数据
+func deepCopy(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, vv := range val {
+			m[k] = deepCopy(vv)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(val))
+		for i, vv := range val {
+			arr[i] = deepCopy(vv)
+		}
+		return arr
+	}
+	return v
+}
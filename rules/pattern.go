@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"errors"
+	"strings"
+)
+
+// pattern gin风格的路径模式，支持 :param 匹配单段以及 *param 匹配剩余全部路径
+type pattern struct {
+	segments []string
+	catchAll bool
+}
+
+func compilePattern(path string) (*pattern, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New("路径必须以 / 开头")
+	}
+	p := &pattern{segments: splitSegments(path)}
+	for i, seg := range p.segments {
+		if strings.HasPrefix(seg, "*") {
+			if i != len(p.segments)-1 {
+				return nil, errors.New("*参数只能位于路径末尾")
+			}
+			p.segments = p.segments[:i]
+			p.catchAll = true
+			break
+		}
+		if seg == ":" {
+			return nil, errors.New(":参数缺少名称")
+		}
+	}
+	return p, nil
+}
+
+func (p *pattern) match(path string) bool {
+	segments := splitSegments(path)
+	if len(segments) < len(p.segments) {
+		return false
+	}
+	if !p.catchAll && len(segments) != len(p.segments) {
+		return false
+	}
+	for i, seg := range p.segments {
+		if strings.HasPrefix(seg, ":") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if seg != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSegments 按 / 拆分路径，忽略开头与结尾的 /
+func splitSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,248 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// 改写的目标
+const (
+	TargetQuery  = "query"  // url参数
+	TargetForm   = "form"   // 表单
+	TargetHeader = "header" // 请求头或响应头
+	TargetJSON   = "json"   // JSON请求体
+	TargetParam  = "param"  // GET/HEAD/DELETE 为url参数，其他方法为表单
+	TargetBody   = "body"   // 响应体，响应操作的默认目标
+)
+
+// OpAdd 追加一个值，保留已有的值，仅用于 query/form/header
+const OpAdd = "add"
+
+// 请求体的解析状态
+const (
+	bodyRaw = iota
+	bodyForm
+	bodyJSON
+)
+
+// Request 待改写的上游请求
+type Request struct {
+	Method string
+	Header http.Header
+	Query  url.Values
+
+	body     []byte
+	bodyKind int
+	form     url.Values
+	json     interface{}
+}
+
+// NewRequest 读取客户端请求，生成可改写的副本
+func NewRequest(r *http.Request) (*Request, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("读取请求体失败：%w", err)
+		}
+	}
+	return &Request{
+		Method: r.Method,
+		Header: r.Header.Clone(),
+		Query:  r.URL.Query(),
+		body:   body,
+	}, nil
+}
+
+// Body 返回改写后的请求体
+func (req *Request) Body() []byte {
+	switch req.bodyKind {
+	case bodyForm:
+		return []byte(req.form.Encode())
+	case bodyJSON:
+		data, err := json.Marshal(req.json)
+		if err != nil {
+			log.Errorf("序列化JSON请求体失败：%v", err)
+			return req.body
+		}
+		return data
+	}
+	return req.body
+}
+
+// Original 返回客户端发送的原始请求体
+func (req *Request) Original() []byte {
+	return req.body
+}
+
+// ApplyRequest 对请求依次执行规则中的请求操作
+// 操作作用于副本，全部成功后才写回，任一操作失败时请求保持不变
+func (r *Rule) ApplyRequest(req *Request) error {
+	tmp := req.clone()
+	for i := range r.Request {
+		if err := r.Request[i].applyRequest(tmp); err != nil {
+			return fmt.Errorf("规则 %q 第 %d 个请求操作失败：%w", r.Name, i+1, err)
+		}
+	}
+	*req = *tmp
+	return nil
+}
+
+// clone 复制可改写的部分，原始请求体只读，共用同一份
+func (req *Request) clone() *Request {
+	c := *req
+	c.Header = req.Header.Clone()
+	c.Query = cloneValues(req.Query)
+	c.form = cloneValues(req.form)
+	c.json = deepCopy(req.json)
+	return &c
+}
+
+func cloneValues(values url.Values) url.Values {
+	return url.Values(http.Header(values).Clone())
+}
+
+func (o *Operation) validateRequest() error {
+	switch o.Target {
+	case TargetQuery, TargetForm, TargetHeader, TargetParam:
+		return o.validateValues()
+	case TargetJSON:
+		return o.validate()
+	}
+	return fmt.Errorf("未知的改写目标 %q", o.Target)
+}
+
+// validateValues 校验作用于 query/form/header 的操作
+func (o *Operation) validateValues() error {
+	switch o.Op {
+	case OpSet, OpAdd:
+		if o.Value == nil {
+			return fmt.Errorf("%s 操作缺少 value", o.Op)
+		}
+	case OpDelete:
+	default:
+		return fmt.Errorf("%s 不支持操作 %q", o.Target, o.Op)
+	}
+	if o.Path == "" {
+		return errors.New("缺少 path")
+	}
+	return nil
+}
+
+func (o *Operation) applyRequest(req *Request) error {
+	target := o.Target
+	if target == TargetParam {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodDelete:
+			target = TargetQuery
+		default:
+			target = TargetForm
+		}
+	}
+
+	switch target {
+	case TargetQuery:
+		o.applyValues(req.Query)
+	case TargetHeader:
+		o.applyValues(url.Values(req.Header))
+	case TargetForm:
+		if err := req.parseForm(); err != nil {
+			return err
+		}
+		o.applyValues(req.form)
+	case TargetJSON:
+		if err := req.parseJSON(); err != nil {
+			return err
+		}
+		body, err := o.apply(req.json)
+		if err != nil {
+			return err
+		}
+		req.json = body
+	}
+	return nil
+}
+
+// applyValues 修改 url.Values 或 http.Header
+func (o *Operation) applyValues(values url.Values) {
+	key := o.Path
+	if o.Target == TargetHeader {
+		key = http.CanonicalHeaderKey(key)
+	}
+	switch o.Op {
+	case OpSet:
+		values[key] = toStrings(o.Value)
+	case OpAdd:
+		values[key] = append(values[key], toStrings(o.Value)...)
+	case OpDelete:
+		delete(values, key)
+	}
+}
+
+// parseForm 将请求体解析为表单
+func (req *Request) parseForm() error {
+	switch req.bodyKind {
+	case bodyForm:
+		return nil
+	case bodyJSON:
+		return errors.New("请求体已作为JSON解析")
+	}
+	if len(req.body) > 0 && !req.hasContentType("application/x-www-form-urlencoded") {
+		return errors.New("请求体不是表单")
+	}
+	form, err := url.ParseQuery(string(req.body))
+	if err != nil {
+		return fmt.Errorf("解析表单失败：%w", err)
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.form = form
+	req.bodyKind = bodyForm
+	return nil
+}
+
+// parseJSON 将请求体解析为JSON
+func (req *Request) parseJSON() error {
+	switch req.bodyKind {
+	case bodyJSON:
+		return nil
+	case bodyForm:
+		return errors.New("请求体已作为表单解析")
+	}
+	if len(req.body) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(req.body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&req.json); err != nil {
+			return fmt.Errorf("解析JSON请求体失败：%w", err)
+		}
+	} else if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.bodyKind = bodyJSON
+	return nil
+}
+
+func (req *Request) hasContentType(expected string) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == expected
+}
+
+// toStrings 将规则中的值转换为字符串列表
+func toStrings(v interface{}) []string {
+	if arr, ok := v.([]interface{}); ok {
+		values := make([]string, 0, len(arr))
+		for _, vv := range arr {
+			values = append(values, fmt.Sprint(vv))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(v)}
+}
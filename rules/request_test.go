@@ -0,0 +1,261 @@
+package rules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"idv-login-go/constants"
+)
+
+func newRequest(t *testing.T, method string, target string, contentType string, body string) *Request {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	req, err := NewRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func applyRule(t *testing.T, req *Request, ops ...Operation) error {
+	t.Helper()
+	rs, err := NewRuleSet([]*Rule{{Name: "test", Path: "/*all", Request: ops}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.rules[0].ApplyRequest(req)
+}
+
+func TestApplyRequest(t *testing.T) {
+	const form = "application/x-www-form-urlencoded"
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		ops         []Operation
+		wantQuery   string
+		wantHeader  map[string]string
+		wantBody    string
+	}{
+		{
+			name:      "query",
+			method:    http.MethodGet,
+			target:    "/api?a=1&b=2&c=3",
+			ops:       []Operation{{Op: OpSet, Target: TargetQuery, Path: "a", Value: 9}, {Op: OpAdd, Target: TargetQuery, Path: "b", Value: []interface{}{"x", "y"}}, {Op: OpDelete, Target: TargetQuery, Path: "c"}},
+			wantQuery: "a=9&b=2&b=x&b=y",
+		},
+		{
+			name:       "header",
+			method:     http.MethodGet,
+			target:     "/api",
+			ops:        []Operation{{Op: OpSet, Target: TargetHeader, Path: "x-client", Value: "pc"}},
+			wantHeader: map[string]string{"X-Client": "pc"},
+		},
+		{
+			name:        "form",
+			method:      http.MethodPost,
+			target:      "/api?q=1",
+			contentType: form,
+			body:        "username=a&cv=old",
+			ops:         []Operation{{Op: OpSet, Target: TargetForm, Path: "cv", Value: "new"}, {Op: OpDelete, Target: TargetForm, Path: "username"}},
+			wantQuery:   "q=1",
+			wantBody:    "cv=new",
+		},
+		{
+			name:       "form on empty body",
+			method:     http.MethodPost,
+			target:     "/api",
+			ops:        []Operation{{Op: OpSet, Target: TargetParam, Path: "cv", Value: "new"}},
+			wantHeader: map[string]string{"Content-Type": form},
+			wantBody:   "cv=new",
+		},
+		{
+			name:      "param on get",
+			method:    http.MethodGet,
+			target:    "/api?cv=old",
+			ops:       []Operation{{Op: OpSet, Target: TargetParam, Path: "cv", Value: "new"}},
+			wantQuery: "cv=new",
+		},
+		{
+			name:        "json",
+			method:      http.MethodPost,
+			target:      "/api",
+			contentType: "application/json",
+			body:        `{"id":12345678901234567890,"device":{"os":"android"},"tags":["a"]}`,
+			ops: []Operation{
+				{Op: OpSet, Target: TargetJSON, Path: "device.os", Value: "windows"},
+				{Op: OpAppend, Target: TargetJSON, Path: "tags", Value: "b"},
+			},
+			wantBody: `{"device":{"os":"windows"},"id":12345678901234567890,"tags":["a","b"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(t, tt.method, tt.target, tt.contentType, tt.body)
+			if err := applyRule(t, req, tt.ops...); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Query.Encode(); got != tt.wantQuery {
+				t.Errorf("query = %q, want %q", got, tt.wantQuery)
+			}
+			for k, v := range tt.wantHeader {
+				if got := req.Header.Get(k); got != v {
+					t.Errorf("header %s = %q, want %q", k, got, v)
+				}
+			}
+			if got := string(req.Body()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+			if got := string(req.Original()); got != tt.body {
+				t.Errorf("original body = %s, want %s", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestApplyRequestAtomic(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		ops         []Operation
+	}{
+		{
+			name:        "form on json body",
+			contentType: "application/json",
+			body:        `{"a":1}`,
+			ops:         []Operation{{Op: OpSet, Target: TargetQuery, Path: "cv", Value: "new"}, {Op: OpSet, Target: TargetForm, Path: "a", Value: 2}},
+		},
+		{
+			name:        "json after form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "a=1",
+			ops:         []Operation{{Op: OpSet, Target: TargetForm, Path: "a", Value: 2}, {Op: OpSet, Target: TargetJSON, Path: "a", Value: 2}},
+		},
+		{
+			name:        "invalid json",
+			contentType: "application/json",
+			body:        `{"a":`,
+			ops:         []Operation{{Op: OpSet, Target: TargetHeader, Path: "X-Test", Value: "1"}, {Op: OpSet, Target: TargetJSON, Path: "a", Value: 2}},
+		},
+		{
+			name:        "bad array index",
+			contentType: "application/json",
+			body:        `{"list":[{"a":1}]}`,
+			ops:         []Operation{{Op: OpSet, Target: TargetJSON, Path: "list.0.a", Value: 2}, {Op: OpSet, Target: TargetJSON, Path: "list.x", Value: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(t, http.MethodPost, "/api?cv=old", tt.contentType, tt.body)
+			header := req.Header.Clone()
+			if err := applyRule(t, req, tt.ops...); err == nil {
+				t.Fatal("want error")
+			}
+			// 失败的规则不应留下部分修改
+			if got := req.Query.Encode(); got != "cv=old" {
+				t.Errorf("query = %q", got)
+			}
+			if !reflect.DeepEqual(req.Header, header) {
+				t.Errorf("header = %v, want %v", req.Header, header)
+			}
+			if got := string(req.Body()); got != tt.body {
+				t.Errorf("body = %s, want %s", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestApplyRequestSequence(t *testing.T) {
+	// 同一请求依次应用多条规则，失败的规则不影响之前与之后的规则
+	req := newRequest(t, http.MethodPost, "/api", "application/json", `{"a":1}`)
+	if err := applyRule(t, req, Operation{Op: OpSet, Target: TargetJSON, Path: "a", Value: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRule(t, req, Operation{Op: OpSet, Target: TargetJSON, Path: "b", Value: 3}, Operation{Op: OpSet, Target: TargetForm, Path: "c", Value: 4}); err == nil {
+		t.Fatal("want error")
+	}
+	if err := applyRule(t, req, Operation{Op: OpSet, Target: TargetJSON, Path: "d", Value: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(req.Body()); got != `{"a":2,"d":5}` {
+		t.Errorf("body = %s", got)
+	}
+}
+
+func TestDefaultRequestRules(t *testing.T) {
+	rs, err := NewRuleSet(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method    string
+		target    string
+		body      string
+		wantQuery url.Values
+		wantForm  url.Values
+	}{
+		{
+			method:    http.MethodGet,
+			target:    "/mpay/games/aecfrt3rmaaaaajl-g-g37/login_methods?cv=a1.0.0&app_channel=netease",
+			wantQuery: url.Values{"cv": {constants.Pcv}, "app_channel": {"netease"}},
+		},
+		{
+			method:    http.MethodGet,
+			target:    "/mpay/games/pc_config?game_id=g",
+			wantQuery: url.Values{"cv": {constants.Icv}, "game_id": {"g"}},
+		},
+		{
+			method:    http.MethodPost,
+			target:    "/mpay/api/users/login/mobile/finish?un=1",
+			body:      "cv=a1.0.0&mobile=123",
+			wantQuery: url.Values{"un": {"1"}},
+			wantForm:  url.Values{"cv": {constants.Icv}, "mobile": {"123"}},
+		},
+		{
+			method:    http.MethodPost,
+			target:    "/mpay/games/g/devices/d/users",
+			body:      "cv=a1.0.0",
+			wantQuery: url.Values{},
+			wantForm:  url.Values{"cv": {constants.Icv}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			contentType := ""
+			if tt.body != "" {
+				contentType = "application/x-www-form-urlencoded"
+			}
+			req := newRequest(t, tt.method, tt.target, contentType, tt.body)
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched := rs.Match(constants.Localhost, tt.method, u.Path)
+			if len(matched) != 1 {
+				t.Fatalf("matched %d rules, want 1", len(matched))
+			}
+			if err := matched[0].ApplyRequest(req); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(req.Query, tt.wantQuery) {
+				t.Errorf("query = %v, want %v", req.Query, tt.wantQuery)
+			}
+			if tt.wantForm != nil {
+				form, err := url.ParseQuery(string(req.Body()))
+				if err != nil || !reflect.DeepEqual(form, tt.wantForm) {
+					t.Errorf("form = %s, want %v", req.Body(), tt.wantForm)
+				}
+			}
+		})
+	}
+}
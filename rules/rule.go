@@ -0,0 +1,252 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+	"github.com/sirupsen/logrus"
+	"idv-login-go/config"
+	"idv-login-go/logger"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// 响应体操作类型
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpMerge  = "merge"
+	OpAppend = "append"
+	OpRename = "rename"
+)
+
+// MethodAny 匹配任意请求方法
+const MethodAny = "ANY"
+
+var log *logrus.Logger
+var conf *config.Config
+
+// Operation 一条改写操作
+type Operation struct {
+	Op     string      `koanf:"op"`     // 操作类型
+	Target string      `koanf:"target"` // 请求操作的目标：query/form/header/json/param，响应操作的目标：body/header
+	Path   string      `koanf:"path"`   // JSON路径，以.分隔，*匹配全部子节点，数字为数组下标；query/form/header 为键名
+	Value  interface{} `koanf:"value"`  // set/add/merge/append 使用的值
+	To     string      `koanf:"to"`     // rename 的新键名
+}
+
+// Rule 一条改写规则
+type Rule struct {
+	Name     string      `koanf:"name"`     // 规则名，与内置规则同名时覆盖内置规则
+	Host     string      `koanf:"host"`     // 主机名，支持 *.example.com，留空匹配全部
+	Method   string      `koanf:"method"`   // 请求方法，ANY或留空匹配全部
+	Path     string      `koanf:"path"`     // 路径，语法与gin路由相同
+	Request  []Operation `koanf:"request"`  // 请求操作
+	Response []Operation `koanf:"response"` // 响应操作
+
+	pattern *pattern
+}
+
+// RuleSet 规则集合，按顺序匹配
+type RuleSet struct {
+	rules []*Rule
+}
+
+// Load 加载内置规则、配置文件中的规则以及规则文件中的规则
+func Load() (*RuleSet, error) {
+	log = logger.GetLogger()
+	conf = config.GetConfig()
+
+	var list []*Rule
+	if conf.Bool("defaultRules") {
+		// 内置规则只作用于 host
+		for _, r := range DefaultRules() {
+			r.Host = conf.String("host")
+			list = append(list, r)
+		}
+	}
+
+	// config.toml 中的规则
+	var custom []*Rule
+	if err := conf.Unmarshal("rules", &custom); err != nil {
+		return nil, fmt.Errorf("解析配置文件中的规则失败：%w", err)
+	}
+
+	// 规则文件中的规则
+	if rulesPath := conf.String("rulesPath"); rulesPath != "" {
+		fileRules, err := loadFile(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		custom = append(custom, fileRules...)
+	}
+
+	for _, r := range custom {
+		list = mergeRule(list, r)
+	}
+	return NewRuleSet(list)
+}
+
+// loadFile 从单独的规则文件加载规则，文件不存在时忽略
+func loadFile(path string) ([]*Rule, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	k := koanf.New(".")
+	if err := k.Load(file.Provider(path), toml.Parser()); err != nil {
+		return nil, fmt.Errorf("加载规则文件失败：%w", err)
+	}
+	var list []*Rule
+	if err := k.Unmarshal("rules", &list); err != nil {
+		return nil, fmt.Errorf("解析规则文件失败：%w", err)
+	}
+	log.Infof("从 %s 加载了 %d 条规则", path, len(list))
+	return list, nil
+}
+
+// mergeRule 同名规则覆盖，否则追加
+func mergeRule(list []*Rule, r *Rule) []*Rule {
+	if r.Name != "" {
+		for i, old := range list {
+			if old.Name == r.Name {
+				list[i] = r
+				return list
+			}
+		}
+	}
+	return append(list, r)
+}
+
+// NewRuleSet 校验并编译规则
+func NewRuleSet(list []*Rule) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for _, r := range list {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("规则 %q 无效：%w", r.Name, err)
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// Match 返回匹配请求的全部规则
+func (rs *RuleSet) Match(host string, method string, path string) []*Rule {
+	if rs == nil {
+		return nil
+	}
+	var matched []*Rule
+	for _, r := range rs.rules {
+		if r.matchHost(host) && r.matchMethod(method) && r.pattern.match(path) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// Len 规则数量
+func (rs *RuleSet) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.rules)
+}
+
+// ApplyResponse 对响应体依次执行规则中的响应体操作
+func (r *Rule) ApplyResponse(body interface{}) (interface{}, error) {
+	for i := range r.Response {
+		if r.Response[i].Target == TargetHeader {
+			continue
+		}
+		var err error
+		body, err = r.Response[i].apply(body)
+		if err != nil {
+			return body, fmt.Errorf("规则 %q 第 %d 个操作失败：%w", r.Name, i+1, err)
+		}
+	}
+	return body, nil
+}
+
+// ApplyResponseHeader 对响应头依次执行规则中的响应头操作
+func (r *Rule) ApplyResponseHeader(header http.Header) {
+	for i := range r.Response {
+		if r.Response[i].Target == TargetHeader {
+			r.Response[i].applyValues(url.Values(header))
+		}
+	}
+}
+
+// HasResponseBody 是否包含响应体操作
+func (r *Rule) HasResponseBody() bool {
+	for i := range r.Response {
+		if r.Response[i].Target != TargetHeader {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) compile() error {
+	r.Host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(r.Host)), ".")
+
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	if r.Method == "" {
+		r.Method = MethodAny
+	}
+	if r.Method != MethodAny && !validMethod(r.Method) {
+		return fmt.Errorf("不支持的请求方法 %s", r.Method)
+	}
+
+	p, err := compilePattern(r.Path)
+	if err != nil {
+		return err
+	}
+	r.pattern = p
+
+	for i := range r.Request {
+		if err := r.Request[i].validateRequest(); err != nil {
+			return fmt.Errorf("第 %d 个请求操作：%w", i+1, err)
+		}
+	}
+	for i := range r.Response {
+		o := &r.Response[i]
+		var err error
+		switch o.Target {
+		case "", TargetBody:
+			o.Target = TargetBody
+			err = o.validate()
+		case TargetHeader:
+			err = o.validateValues()
+		default:
+			err = fmt.Errorf("未知的改写目标 %q", o.Target)
+		}
+		if err != nil {
+			return fmt.Errorf("第 %d 个响应操作：%w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matchHost(host string) bool {
+	if r.Host == "" || r.Host == host {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(r.Host, "*")
+	return ok && strings.HasSuffix(host, suffix) && !strings.Contains(strings.TrimSuffix(host, suffix), ".")
+}
+
+func (r *Rule) matchMethod(method string) bool {
+	return r.Method == MethodAny || r.Method == method
+}
+
+func validMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package rules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/mpay/games/pc_config", path: "/mpay/games/pc_config", want: true},
+		{pattern: "/mpay/games/pc_config", path: "/mpay/games/pc_config/", want: true},
+		{pattern: "/mpay/games/pc_config", path: "/mpay/games/other", want: false},
+		{pattern: "/mpay/games/pc_config", path: "/mpay/games", want: false},
+		{pattern: "/mpay/games/:game_id/login_methods", path: "/mpay/games/aecfrt3rmaaaaajl-g-g37/login_methods", want: true},
+		{pattern: "/mpay/games/:game_id/login_methods", path: "/mpay/games//login_methods", want: false},
+		{pattern: "/mpay/games/:game_id/login_methods", path: "/mpay/games/a/b/login_methods", want: false},
+		{pattern: "/mpay/games/:game_id", path: "/mpay/games/a/extra", want: false},
+		{pattern: "/mpay/*rest", path: "/mpay/api/users/login", want: true},
+		{pattern: "/mpay/*rest", path: "/mpay", want: true},
+		{pattern: "/mpay/*rest", path: "/other/api", want: false},
+		{pattern: "/*all", path: "/", want: true},
+		{pattern: "/", path: "/", want: true},
+		{pattern: "/", path: "/a", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			p, err := compilePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.match(tt.path); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rs, err := NewRuleSet([]*Rule{
+		{Name: "any", Path: "/*all"},
+		{Name: "get", Host: "Service.MKey.163.com.", Method: "get", Path: "/mpay/games/:game_id"},
+		{Name: "wildcard", Host: "*.nie.netease.com", Method: "POST", Path: "/api"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host   string
+		method string
+		path   string
+		want   []string
+	}{
+		{host: "service.mkey.163.com", method: "GET", path: "/mpay/games/g", want: []string{"any", "get"}},
+		{host: "service.mkey.163.com", method: "POST", path: "/mpay/games/g", want: []string{"any"}},
+		{host: "other.163.com", method: "GET", path: "/mpay/games/g", want: []string{"any"}},
+		{host: "a.nie.netease.com", method: "POST", path: "/api", want: []string{"any", "wildcard"}},
+		{host: "a.b.nie.netease.com", method: "POST", path: "/api", want: []string{"any"}},
+		{host: "nie.netease.com", method: "POST", path: "/api", want: []string{"any"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range rs.Match(tt.host, tt.method, tt.path) {
+			got = append(got, r.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%s, %s, %s) = %q, want %q", tt.host, tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	var nilSet *RuleSet
+	if nilSet.Match("a", "GET", "/") != nil || nilSet.Len() != 0 {
+		t.Error("nil RuleSet should match nothing")
+	}
+}
+
+func TestMergeRule(t *testing.T) {
+	var list []*Rule
+	for _, r := range DefaultRules() {
+		list = mergeRule(list, r)
+	}
+	n := len(list)
+
+	override := &Rule{Name: "pc_config", Path: "/mpay/games/pc_config"}
+	list = mergeRule(list, override)
+	list = mergeRule(list, &Rule{Name: "custom", Path: "/custom"})
+	list = mergeRule(list, &Rule{Path: "/unnamed"})
+	list = mergeRule(list, &Rule{Path: "/unnamed"}) // 没有名称的规则不会互相覆盖
+
+	if len(list) != n+3 {
+		t.Fatalf("got %d rules, want %d", len(list), n+3)
+	}
+	var found bool
+	for _, r := range list {
+		if r.Name == "pc_config" {
+			if r != override {
+				t.Error("pc_config was not overridden")
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("pc_config missing")
+	}
+	if list[n].Name != "custom" || list[n+1].Path != "/unnamed" {
+		t.Errorf("new rules should be appended in order, got %q, %q", list[n].Name, list[n+1].Path)
+	}
+}
+
+func TestApplyResponse(t *testing.T) {
+	body := `{"user":{"id":"u1","ext":{"a":1}},"config":{"x":{"select_platforms":[0]},"y":{"select_platforms":[1]}},"list":[{"id":1},{"id":2},{"id":3}]}`
+	tests := []struct {
+		name string
+		ops  []Operation
+		want string
+	}{
+		{
+			name: "set nested",
+			ops:  []Operation{{Op: OpSet, Path: "user.pc_ext_info", Value: map[string]interface{}{"k": "v"}}},
+			want: `{"user":{"id":"u1","ext":{"a":1},"pc_ext_info":{"k":"v"}},"config":{"x":{"select_platforms":[0]},"y":{"select_platforms":[1]}},"list":[{"id":1},{"id":2},{"id":3}]}`,
+		},
+		{
+			name: "set wildcard",
+			ops:  []Operation{{Op: OpSet, Path: "config.*.select_platforms", Value: []interface{}{0, 1}}},
+			want: `{"user":{"id":"u1","ext":{"a":1}},"config":{"x":{"select_platforms":[0,1]},"y":{"select_platforms":[0,1]}},"list":[{"id":1},{"id":2},{"id":3}]}`,
+		},
+		{
+			name: "set missing parent",
+			ops:  []Operation{{Op: OpSet, Path: "missing.key", Value: 1}},
+			want: body,
+		},
+		{
+			name: "set array index",
+			ops:  []Operation{{Op: OpSet, Path: "list.-1.id", Value: 9}},
+			want: `{"user":{"id":"u1","ext":{"a":1}},"config":{"x":{"select_platforms":[0]},"y":{"select_platforms":[1]}},"list":[{"id":1},{"id":2},{"id":9}]}`,
+		},
+		{
+			name: "delete",
+			ops:  []Operation{{Op: OpDelete, Path: "user.ext"}, {Op: OpDelete, Path: "list.0"}},
+			want: `{"user":{"id":"u1"},"config":{"x":{"select_platforms":[0]},"y":{"select_platforms":[1]}},"list":[{"id":2},{"id":3}]}`,
+		},
+		{
+			name: "merge",
+			ops:  []Operation{{Op: OpMerge, Path: "user", Value: map[string]interface{}{"ext": map[string]interface{}{"b": 2}, "name": "n"}}},
+			want: `{"user":{"id":"u1","ext":{"a":1,"b":2},"name":"n"},"config":{"x":{"select_platforms":[0]},"y":{"select_platforms":[1]}},"list":[{"id":1},{"id":2},{"id":3}]}`,
+		},
+		{
+			name: "append",
+			ops: []Operation{
+				{Op: OpAppend, Path: "config.x.select_platforms", Value: []interface{}{1, 2}},
+				{Op: OpAppend, Path: "config.y.select_platforms", Value: 3},
+				{Op: OpAppend, Path: "user.id", Value: 1}, // 不是数组，保持原样
+			},
+			want: `{"user":{"id":"u1","ext":{"a":1}},"config":{"x":{"select_platforms":[0,1,2]},"y":{"select_platforms":[1,3]}},"list":[{"id":1},{"id":2},{"id":3}]}`,
+		},
+		{
+			name: "rename",
+			ops:  []Operation{{Op: OpRename, Path: "list.*.id", To: "uid"}, {Op: OpRename, Path: "user.missing", To: "x"}},
+			want: `{"user":{"id":"u1","ext":{"a":1}},"config":{"x":{"select_platforms":[0]},"y":{"select_platforms":[1]}},"list":[{"uid":1},{"uid":2},{"uid":3}]}`,
+		},
+		{
+			name: "set root",
+			ops:  []Operation{{Op: OpSet, Path: "$", Value: map[string]interface{}{"code": 0}}},
+			want: `{"code":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := NewRuleSet([]*Rule{{Name: tt.name, Path: "/", Response: tt.ops}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			if err := json.Unmarshal([]byte(body), &v); err != nil {
+				t.Fatal(err)
+			}
+			got, err := rs.rules[0].ApplyResponse(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want interface{}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			// 经过一次序列化，比较时不区分规则中的 int 与JSON中的 float64
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var normalized interface{}
+			if err := json.Unmarshal(data, &normalized); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(normalized, want) {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyResponseInvalidIndex(t *testing.T) {
+	rs, err := NewRuleSet([]*Rule{{Name: "bad", Path: "/", Response: []Operation{{Op: OpSet, Path: "list.x", Value: 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.rules[0].ApplyResponse(map[string]interface{}{"list": []interface{}{1}}); err == nil {
+		t.Error("invalid array index should fail")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want string // 错误信息中应包含的内容
+	}{
+		{name: "relative path", rule: Rule{Path: "mpay"}, want: "/ 开头"},
+		{name: "catch all not last", rule: Rule{Path: "/*a/b"}, want: "末尾"},
+		{name: "empty param", rule: Rule{Path: "/a/:"}, want: "缺少名称"},
+		{name: "method", rule: Rule{Path: "/", Method: "FETCH"}, want: "FETCH"},
+		{name: "unknown op", rule: Rule{Path: "/", Response: []Operation{{Op: "add", Path: "a", Value: 1}}}, want: "未知的操作类型"},
+		{name: "set without value", rule: Rule{Path: "/", Response: []Operation{{Op: OpSet, Path: "a"}}}, want: "缺少 value"},
+		{name: "delete without path", rule: Rule{Path: "/", Response: []Operation{{Op: OpDelete}}}, want: "缺少 path"},
+		{name: "rename without to", rule: Rule{Path: "/", Response: []Operation{{Op: OpRename, Path: "a"}}}, want: "path 与 to"},
+		{name: "merge non object", rule: Rule{Path: "/", Response: []Operation{{Op: OpMerge, Path: "a", Value: 1}}}, want: "必须是对象"},
+		{name: "response target", rule: Rule{Path: "/", Response: []Operation{{Op: OpSet, Target: "query", Path: "a", Value: 1}}}, want: "未知的改写目标"},
+		{name: "request target", rule: Rule{Path: "/", Request: []Operation{{Op: OpSet, Target: "cookie", Path: "a", Value: 1}}}, want: "请求操作"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.rule
+			r.Name = tt.name
+			_, err := NewRuleSet([]*Rule{&r})
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), tt.name) {
+				t.Errorf("error %q should name the rule and contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultRulesValid(t *testing.T) {
+	rs, err := NewRuleSet(DefaultRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Len() != len(DefaultRules()) {
+		t.Errorf("Len() = %d", rs.Len())
+	}
+}
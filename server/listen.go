@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"idv-login-go/constants"
+	"idv-login-go/logger"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultListen 默认只监听本机
+var DefaultListen = []string{constants.Localhost + ":443"}
+
+// ListenError 监听失败，端口被占用时 Owner 为占用端口的进程
+type ListenError struct {
+	Addr  string
+	Owner []string
+	Err   error
+}
+
+func (e *ListenError) Error() string {
+	if len(e.Owner) > 0 {
+		return fmt.Sprintf("监听 %s 失败，端口已被 %s 占用：%v", e.Addr, strings.Join(e.Owner, "、"), e.Err)
+	}
+	return fmt.Sprintf("监听 %s 失败：%v", e.Addr, e.Err)
+}
+
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// NormalizeListen 补全监听地址，省略端口时使用443，如 ::1 变为 [::1]:443
+func NormalizeListen(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), "443"
+	}
+	if host != "" && host != "localhost" && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("监听地址 %q 无效，只能填写IP或 localhost", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("监听地址 %q 的端口无效", addr)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Listen 监听地址，端口被占用时查找占用端口的进程
+func Listen(addr string) (net.Listener, error) {
+	log = logger.GetLogger()
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		return ln, nil
+	}
+	listenErr := &ListenError{Addr: addr, Err: err}
+	if errors.Is(err, errAddrInUse) {
+		if _, port, splitErr := net.SplitHostPort(addr); splitErr == nil {
+			if n, convErr := strconv.Atoi(port); convErr == nil {
+				owners, ownerErr := portOwners(n)
+				if ownerErr != nil {
+					log.Debugf("查找占用端口 %d 的进程失败：%v", n, ownerErr)
+				}
+				listenErr.Owner = owners
+			}
+		}
+	}
+	return nil, listenErr
+}
+
+// CheckListen 检查地址能否监听
+func CheckListen(addr string) error {
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// covers 监听地址能否收到发往 ip:port 的连接
+func covers(addrs []string, ip string, port string) bool {
+	for _, addr := range addrs {
+		host, p, err := net.SplitHostPort(addr)
+		if err != nil || p != port {
+			continue
+		}
+		if host == "" || host == ip || host == "0.0.0.0" || host == "::" || host == "localhost" && ip == constants.Localhost {
+			return true
+		}
+	}
+	return false
+}
+
+// owner 进程的描述
+func owner(name string, pid int) string {
+	if name == "" {
+		return fmt.Sprintf("PID %d", pid)
+	}
+	return fmt.Sprintf("%s（PID %d）", name, pid)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeListen(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{addr: "127.0.0.1:443", want: "127.0.0.1:443"},
+		{addr: " 127.0.0.1:8443 ", want: "127.0.0.1:8443"},
+		{addr: "127.0.0.1", want: "127.0.0.1:443"},
+		{addr: "::1", want: "[::1]:443"},
+		{addr: "[::1]", want: "[::1]:443"},
+		{addr: "[::1]:8443", want: "[::1]:8443"},
+		{addr: "0.0.0.0", want: "0.0.0.0:443"},
+		{addr: ":443", want: ":443"},
+		{addr: "localhost", want: "localhost:443"},
+		{addr: "localhost:8443", want: "localhost:8443"},
+		{addr: "example.com:443", wantErr: true},
+		{addr: "127.0.0.1:0", wantErr: true},
+		{addr: "127.0.0.1:65536", wantErr: true},
+		{addr: "127.0.0.1:https", wantErr: true},
+		{addr: "1.2.3:443", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := NormalizeListen(tt.addr)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("NormalizeListen(%q) = %q, want error", tt.addr, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeListen(%q) = %q, %v, want %q", tt.addr, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		name  string
+		addrs []string
+		ip    string
+		port  string
+		want  bool
+	}{
+		{name: "exact", addrs: []string{"127.0.0.1:443"}, ip: "127.0.0.1", port: "443", want: true},
+		{name: "other port", addrs: []string{"127.0.0.1:8443"}, ip: "127.0.0.1", port: "443", want: false},
+		{name: "other ip", addrs: []string{"127.0.0.2:443"}, ip: "127.0.0.1", port: "443", want: false},
+		{name: "ipv6 only", addrs: []string{"[::1]:443"}, ip: "127.0.0.1", port: "443", want: false},
+		{name: "second address", addrs: []string{"[::1]:443", "127.0.0.1:443"}, ip: "127.0.0.1", port: "443", want: true},
+		{name: "all interfaces", addrs: []string{":443"}, ip: "127.0.0.1", port: "443", want: true},
+		{name: "ipv4 any", addrs: []string{"0.0.0.0:443"}, ip: "127.0.0.1", port: "443", want: true},
+		{name: "ipv6 any", addrs: []string{"[::]:443"}, ip: "127.0.0.1", port: "443", want: true},
+		{name: "localhost", addrs: []string{"localhost:443"}, ip: "127.0.0.1", port: "443", want: true},
+		{name: "localhost other ip", addrs: []string{"localhost:443"}, ip: "192.168.1.2", port: "443", want: false},
+		{name: "invalid", addrs: []string{"127.0.0.1"}, ip: "127.0.0.1", port: "443", want: false},
+		{name: "empty", addrs: nil, ip: "127.0.0.1", port: "443", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := covers(tt.addrs, tt.ip, tt.port); got != tt.want {
+				t.Errorf("covers(%q, %s, %s) = %v, want %v", tt.addrs, tt.ip, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	err = CheckListen(addr)
+	var listenErr *ListenError
+	if !errors.As(err, &listenErr) {
+		t.Fatalf("CheckListen(%s) = %v, want ListenError", addr, err)
+	}
+	if listenErr.Addr != addr || !errors.Is(err, errAddrInUse) {
+		t.Errorf("ListenError = %+v", listenErr)
+	}
+	// Linux 上从 /proc 查找占用端口的进程，应能找到本测试进程
+	if runtime.GOOS == "linux" {
+		pid := fmt.Sprintf("PID %d", os.Getpid())
+		found := false
+		for _, o := range listenErr.Owner {
+			found = found || strings.Contains(o, pid)
+		}
+		if !found {
+			t.Errorf("Owner = %q, want %s", listenErr.Owner, pid)
+		}
+		if !strings.Contains(err.Error(), pid) {
+			t.Errorf("error %q does not name the owner", err)
+		}
+	}
+
+	ln.Close()
+	if err := CheckListen(addr); err != nil {
+		t.Errorf("CheckListen(%s) after close = %v", addr, err)
+	}
+}
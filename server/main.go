@@ -2,75 +2,153 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/goccy/go-json"
 	"github.com/imroc/req/v3"
 	"github.com/sirupsen/logrus"
+	"idv-login-go/certController"
 	"idv-login-go/constants"
+	"idv-login-go/harController"
 	"idv-login-go/logger"
+	"idv-login-go/rules"
+	"idv-login-go/upstreamController"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
-var cvType = struct {
-	Pc   *string
-	Ios  *string
-	Code *string
-}{
-	Pc:   func() *string { s := constants.Pcv; return &s }(),
-	Ios:  func() *string { s := constants.Icv; return &s }(),
-	Code: func() *string { s := constants.Ccv; return &s }(),
+type Server struct {
+	upstreams []*upstream
+	byHost    map[string]*upstream
+	ginServer *gin.Engine
+	rules     *rules.RuleSet
+	issuer    *certController.Issuer
+	resolver  *net.Resolver
+	listen    []string
+	recorder  *harController.Recorder
+	stats     *Stats
+
+	httpServer *http.Server
+	done       chan error
 }
 
-type Server struct {
-	targetHost   string
-	redirectHost string
-	urlRedirect  string
-	client       *req.Client
-	ginServer    *gin.Engine
+// upstream 一个拦截的主机名及其上游
+type upstream struct {
+	host        string
+	urlRedirect *url.URL
+	client      *req.Client
+	pool        *upstreamController.Pool
+	transport   http.RoundTripper
 }
 
-func NewServer(targetHost string, targetIp string) *Server {
+// NewServer pools 为每个拦截主机名的上游池，第一个为默认上游
+func NewServer(pools []*upstreamController.Pool, ruleSet *rules.RuleSet, issuer *certController.Issuer) *Server {
 	log = logger.GetLogger()
-	cli := req.C().EnableInsecureSkipVerify()
-	if constants.DebugMode {
-		cli.DevMode()
+	s := &Server{
+		byHost:   make(map[string]*upstream),
+		rules:    ruleSet,
+		issuer:   issuer,
+		resolver: net.DefaultResolver,
+		listen:   DefaultListen,
+	}
+	for _, pool := range pools {
+		// 关闭自动解码，保证透传的响应与上游逐字节一致
+		// 代理只使用 transport，不经过 req 的重定向处理，3xx 原样返回给客户端
+		// 请求发往真实主机名，连接时由 pool 选择IP并完成TLS握手
+		cli := req.C().
+			SetDialTLS(pool.DialTLSContext).
+			EnableForceHTTP1().
+			DisableAutoDecode()
+		if constants.DebugMode {
+			cli.DevMode()
+		}
+		u := &upstream{
+			host:        pool.Host(),
+			urlRedirect: &url.URL{Scheme: "https", Host: pool.Host()},
+			client:      cli,
+			pool:        pool,
+			// 由上游池记录各IP的成败，幂等请求失败时换IP重试
+			transport: pool.Transport(cli.GetTransport()),
+		}
+		s.upstreams = append(s.upstreams, u)
+		s.byHost[u.host] = u
 	}
+	return s
+}
 
-	return &Server{
-		targetHost:   targetHost,
-		redirectHost: targetIp,
-		urlRedirect:  fmt.Sprintf("https://%s", targetIp),
-		client:       cli,
+// SetResolver 设置检查重定向时使用的Resolver，本地DNS服务器模式下使用该服务器解析
+func (s *Server) SetResolver(resolver *net.Resolver) {
+	s.resolver = resolver
+}
+
+// SetListen 设置监听地址，为空时只监听本机
+func (s *Server) SetListen(addrs []string) {
+	if len(addrs) == 0 {
+		addrs = DefaultListen
 	}
+	s.listen = addrs
 }
 
-var log *logrus.Logger
+// SetRecorder 设置HAR记录，为空时不记录
+func (s *Server) SetRecorder(recorder *harController.Recorder) {
+	s.recorder = recorder
+}
 
-func (s *Server) Run(shutChan chan bool) {
-	// 检查重定向情况
-	ip, err := net.LookupHost(s.targetHost)
-	if err != nil {
-		log.Errorf("LookupHost失败：%v", err)
+// SetStats 设置请求统计，为空时不统计
+func (s *Server) SetStats(stats *Stats) {
+	s.stats = stats
+}
+
+// SetReplay 使用回放记录代替上游，为空时请求真实上游
+func (s *Server) SetReplay(replay *harController.Replay) {
+	if replay == nil {
 		return
 	}
+	for _, u := range s.upstreams {
+		u.transport = replay.Transport(u.transport)
+	}
+}
 
-	if strings.Compare(constants.Localhost, ip[0]) != 0 {
-		log.Errorf("重定向IP不一致，目标IP：%s，解析IP：%s", constants.Localhost, ip[0])
-		return
+var log *logrus.Logger
+
+// Start 检查重定向与端口后在后台启动代理服务器，检查失败时返回错误
+// 启动后意外退出的错误通过 Done 返回
+func (s *Server) Start() error {
+	// 检查重定向情况
+	for _, u := range s.upstreams {
+		ip, err := s.resolver.LookupHost(context.Background(), u.host)
+		if err != nil {
+			return fmt.Errorf("LookupHost失败：%w", err)
+		}
+
+		if strings.Compare(constants.Localhost, ip[0]) != 0 {
+			return fmt.Errorf("%s 重定向IP不一致，目标IP：%s，解析IP：%s", u.host, constants.Localhost, ip[0])
+		}
+		log.Infof("%s 重定向IP一致，目标IP：%s，解析IP：%s", u.host, constants.Localhost, ip[0])
 	}
-	log.Infof("重定向IP一致，目标IP：%s，解析IP：%s", constants.Localhost, ip[0])
 
-	// 检查端口占用
-	if done, err := s.checkPort(); !done || err != nil {
-		log.Errorf("端口检查失败：%v", err)
-		return
+	// 监听全部地址，任意一个失败时关闭已监听的地址并返回
+	addrs, err := s.listenAddrs()
+	if err != nil {
+		return err
+	}
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		ln, err := Listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
 	}
-	log.Info("端口检查成功")
+	log.Infof("端口检查成功，监听 %s", strings.Join(addrs, "、"))
 
 	// 启动代理服务器
 	log.Info("启动代理服务器...")
@@ -81,203 +159,110 @@ func (s *Server) Run(shutChan chan bool) {
 	s.ginServer = gin.Default()
 	s.setupRoutes()
 
-	srv := &http.Server{
-		Addr:    ":443",
+	s.httpServer = &http.Server{
 		Handler: s.ginServer,
+		// 证书在握手时按SNI签发
+		TLSConfig: &tls.Config{
+			GetCertificate: s.issuer.GetCertificate,
+		},
+	}
+	s.done = make(chan error, len(listeners))
+
+	// 使用TLS启动服务器，每个地址一个协程，全部退出后关闭 done
+	srv, done := s.httpServer, s.done
+	var wg sync.WaitGroup
+	for _, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			if err := srv.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorf("代理服务器在 %s 上运行失败：%v", ln.Addr(), err)
+				done <- err
+			}
+		}(ln)
 	}
-
-	// 使用TLS证书和私钥启动服务器
 	go func() {
-		if err := srv.ListenAndServeTLS(constants.CertPath, constants.KeyPath); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			{
-				log.Fatalf("代理服务器运行失败：%v", err)
-				return
+		wg.Wait()
+		close(done)
+	}()
+	return nil
+}
+
+// listenAddrs 补全并去重监听地址，客户端连接的地址不在其中或会暴露给局域网时给出警告
+func (s *Server) listenAddrs() ([]string, error) {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, addr := range s.listen {
+		addr, err := NormalizeListen(addr)
+		if err != nil {
+			return nil, err
+		}
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+		if host, _, _ := net.SplitHostPort(addr); host != "localhost" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+				log.Warnf("代理服务器监听 %s，局域网内的其他设备也可以访问", addr)
 			}
 		}
-	}()
+	}
+	if !covers(addrs, constants.Localhost, "443") {
+		log.Warnf("hosts 或本地DNS将主机名指向 %s:443，但代理没有监听该地址，客户端可能无法连接", constants.Localhost)
+	}
+	return addrs, nil
+}
 
-	// 等待中断信号
-	<-shutChan
+// Done 代理服务器退出时关闭，意外退出时先返回错误
+func (s *Server) Done() <-chan error {
+	return s.done
+}
+
+// Shutdown 优雅关闭代理服务器，将未处理完的请求处理完再关闭，超过5秒就超时退出
+func (s *Server) Shutdown() error {
+	if s.httpServer == nil {
+		return nil
+	}
 	log.Info("代理服务器关闭...")
 
 	// 创建一个 5 秒的超时上下文
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-
-	// 关闭 HTTP Server
-	// 5秒内优雅关闭服务（将未处理完的请求处理完再关闭服务），超过5秒就超时退出
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("代理服务器关闭出错：%v", err)
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("代理服务器关闭出错：%w", err)
 	}
 	log.Info("代理服务器已关闭")
+	return nil
 }
 
-// setupRoutes 设置路由
+// setupRoutes 设置路由，请求与响应的改写均由规则决定
 func (s *Server) setupRoutes() {
 	g := s.ginServer
-	// 修改登录方法
-	g.GET("/mpay/games/:game_id/login_methods", s.handleLoginMethods)
-	// 首次登录
-	g.POST("/mpay/api/users/login/mobile/finish", s.handleFirstLogin)
-	g.POST("/mpay/api/users/login/mobile/get_sms", s.handleFirstLogin)
-	g.POST("/mpay/api/users/login/mobile/verify_sms", s.handleFirstLogin)
-	g.POST("/mpay/games/:game_id/devices/:device_id/users", s.handleFirstLogin)
-	// 登录
-	g.GET("/mpay/games/:game_id/devices/:device_id/users/:user_id", s.handleLogin)
-	// 更改审核状态
-	g.GET("/mpay/games/pc_config", s.handlePcConfig)
-	// 其他
-	g.Any("/:path/*path", s.handleAllRest)
+	g.Use(s.observe)
+	g.Any("/*path", s.handleRewrite)
 }
 
-// handleAllRest 处理所有请求
-func (s *Server) handleAllRest(c *gin.Context) {
-	rsp, done := s.getProxyReturn(c, nil)
-	if !done {
-		return
-	}
-
-	s.returnByteToJson(c, rsp)
-}
-
-// handlePcConfig 更改审核状态
-func (s *Server) handlePcConfig(c *gin.Context) {
-	rsp, done := s.getProxyReturn(c, cvType.Ios)
-	if !done {
-		return
-	}
-
-	// 修改响应
-	newBody := s.modifyResponse(rsp, func(newBody *map[string]interface{}) {
-		if game, ok := (*newBody)["game"].(map[string]interface{}); ok {
-			if config, ok := game["config"].(map[string]interface{}); ok {
-				config["cv_review_status"] = 1
-			}
-		}
-	})
-	c.JSON(rsp.StatusCode, newBody)
-}
-
-// handleLogin 登录
-func (s *Server) handleLogin(c *gin.Context) {
-	rsp, done := s.getProxyReturn(c, cvType.Ios)
-	if !done {
-		return
-	}
-
-	// 修改响应
-	newBody := s.modifyResponse(rsp, func(newBody *map[string]interface{}) {
-		if user, ok := (*newBody)["user"].(map[string]interface{}); ok {
-			user["pc_ext_info"] = constants.PcInfo
-		}
-	})
-	c.JSON(rsp.StatusCode, newBody)
-}
-
-// handleFirstLogin 首次登录
-func (s *Server) handleFirstLogin(c *gin.Context) {
-	rsp, done := s.getProxyReturn(c, cvType.Ios)
-	if !done {
-		return
-	}
-
-	s.returnByteToJson(c, rsp)
-}
-
-// handleLoginMethods 修改登录方法
-func (s *Server) handleLoginMethods(c *gin.Context) {
-	rsp, done := s.getProxyReturn(c, cvType.Pc)
-	if !done {
-		return
-	}
-
-	// 修改响应
-	newBody := s.modifyResponse(rsp, func(newBody *map[string]interface{}) {
-		(*newBody)["select_platform"] = true
-		(*newBody)["qrcode_select_platform"] = true
-		if config, ok := (*newBody)["config"].(map[string]interface{}); ok {
-			for _, v := range config {
-				if configMap, ok := v.(map[string]interface{}); ok {
-					configMap["select_platforms"] = []interface{}{0, 1, 2, 3, 4}
-				}
-			}
+// route 按请求的Host选择上游，Host不是拦截的主机名时按SNI选择，都不匹配时使用默认上游
+func (s *Server) route(r *http.Request) *upstream {
+	if host := normalizeHost(r.Host); host != "" {
+		if u, ok := s.byHost[host]; ok {
+			return u
 		}
-	})
-	c.JSON(rsp.StatusCode, newBody)
-}
-
-// modifyResponse 修改body
-func (s *Server) modifyResponse(rsp *req.Response, callback func(body *map[string]interface{})) map[string]interface{} {
-	var newBody map[string]interface{}
-	json.Unmarshal(rsp.Bytes(), &newBody)
-
-	// 处理
-	callback(&newBody)
-	return newBody
-}
-
-// returnByteToJson 返回byte转json
-func (s *Server) returnByteToJson(c *gin.Context, rsp *req.Response) {
-	var temp gin.H
-	json.Unmarshal(rsp.Bytes(), &temp)
-	c.JSON(rsp.StatusCode, temp)
-}
-
-// getProxyReturn 获取代理返回
-func (s *Server) getProxyReturn(c *gin.Context, cv *string) (*req.Response, bool) {
-	reqs := c.Request
-
-	rsp := s.proxy(reqs, cv)
-	if rsp.Err != nil {
-		log.Errorf("请求失败：%v", rsp.Err)
-		c.JSON(http.StatusInternalServerError, gin.H{"reason": rsp.Err.Error()})
-		return rsp, false
 	}
-	return rsp, true
-}
-
-// proxy 代理请求
-func (s *Server) proxy(r *http.Request, cv *string) *req.Response {
-	client := s.client
-	urlPath := r.URL.Path
-
-	// 处理url
-	reqs := client.R()
-	// 设置header
-	s.setReqValues(r.Header, reqs.SetHeader)
-
-	// 判断是否覆盖cv
-	if cv != nil {
-		// 判断方法
-		if r.Method == http.MethodGet {
-			newQuery := r.URL.Query()
-			newQuery.Set("cv", *cv)
-			s.setReqValues(newQuery, reqs.AddQueryParam)
-		} else if r.Method == http.MethodPost {
-			r.ParseForm()
-			r.PostForm.Set("cv", *cv)
-			newBody := r.PostForm.Encode()
-			reqs = reqs.SetBodyString(newBody)
+	if r.TLS != nil {
+		if u, ok := s.byHost[normalizeHost(r.TLS.ServerName)]; ok {
+			return u
 		}
 	}
-	rsp, _ := reqs.Send(r.Method, s.urlRedirect+urlPath)
-
-	return rsp
+	log.Debugf("%s 不是拦截的主机名，使用默认上游 %s", r.Host, s.upstreams[0].host)
+	return s.upstreams[0]
 }
 
-func (s *Server) checkPort() (bool, error) {
-	ln, err := net.Listen("tcp", ":443")
-	if err != nil {
-		return false, err
-	}
-	defer ln.Close()
-	return true, nil
-}
-
-func (s *Server) setReqValues(data map[string][]string, callback func(string, string) *req.Request) {
-	for k, v := range data {
-		for _, vv := range v {
-			callback(k, vv)
-		}
+// normalizeHost 去掉端口并转为小写
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
 	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
 }
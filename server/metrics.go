@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"idv-login-go/harController"
+	"idv-login-go/metrics"
+	"idv-login-go/rules"
+	"idv-login-go/upstreamController"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("idv_proxy_requests_total",
+		"代理的请求数，route 为匹配的改写规则，没有匹配时为 passthrough", "host", "route", "method", "code")
+	requestDuration = metrics.NewHistogram("idv_proxy_request_duration_seconds",
+		"代理请求的耗时（秒）", nil, "host", "route")
+	upstreamErrors = metrics.NewCounter("idv_upstream_errors_total",
+		"请求上游失败的次数，kind 为 verify/replay_miss/timeout/dial/tls/eof/other", "host", "kind")
+)
+
+// routeKey 匹配的改写规则在 gin.Context 中的键
+const routeKey = "route"
+
+// routeName 匹配的改写规则名，用作指标的 route 标签
+func routeName(matched []*rules.Rule) string {
+	if len(matched) == 0 {
+		return "passthrough"
+	}
+	names := make([]string, 0, len(matched))
+	for _, r := range matched {
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, "+")
+}
+
+// observe 记录每次代理的指标与统计
+func (s *Server) observe(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	elapsed := time.Since(start)
+
+	host := s.route(c.Request).host
+	route := c.GetString(routeKey)
+	status := c.Writer.Status()
+	requestsTotal.Inc(host, route, c.Request.Method, strconv.Itoa(status))
+	requestDuration.Observe(elapsed.Seconds(), host, route)
+
+	if s.stats != nil {
+		s.stats.add(RequestRecord{
+			Time:     start,
+			Method:   c.Request.Method,
+			Host:     host,
+			Path:     c.Request.URL.Path,
+			Status:   status,
+			Duration: float64(elapsed.Microseconds()) / 1000,
+			Client:   c.ClientIP(),
+		})
+	}
+}
+
+// errorKind 上游错误的分类
+func errorKind(err error) string {
+	var verifyErr *upstreamController.VerifyError
+	var missErr *harController.MissError
+	var netErr net.Error
+	var opErr *net.OpError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	switch {
+	case errors.As(err, &verifyErr):
+		return "verify"
+	case errors.As(err, &missErr):
+		return "replay_miss"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "dial"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &unknownAuthority):
+		return "tls"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	}
+	return "other"
+}
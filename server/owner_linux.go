@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var errAddrInUse error = syscall.EADDRINUSE
+
+// portOwners 从 /proc/net/tcp 找到监听该端口的socket，再在各进程的 fd 中查找，需要权限才能看到其他用户的进程
+func portOwners(port int) ([]string, error) {
+	inodes := make(map[string]bool)
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		if err := listenInodes(name, port, inodes); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(inodes) == 0 {
+		return nil, fmt.Errorf("没有找到监听端口 %d 的socket", port)
+	}
+
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return nil, err
+	}
+	var owners []string
+	seen := make(map[int]bool)
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.Split(fd, "/")[2])
+		if err != nil || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		comm, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		owners = append(owners, owner(strings.TrimSpace(string(comm)), pid))
+	}
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("没有权限查看占用端口 %d 的进程", port)
+	}
+	return owners, nil
+}
+
+// listenInodes 读取处于 LISTEN 状态（0A）且端口一致的socket的inode
+func listenInodes(name string, port int, inodes map[string]bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+		local := fields[1]
+		p, err := strconv.ParseInt(local[strings.LastIndex(local, ":")+1:], 16, 32)
+		if err != nil || int(p) != port {
+			continue
+		}
+		inodes[fields[9]] = true
+	}
+	return scanner.Err()
+}
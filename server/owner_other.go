@@ -0,0 +1,37 @@
+//go:build !linux && !windows
+
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var errAddrInUse error = syscall.EADDRINUSE
+
+// portOwners 使用 lsof 查找监听该端口的进程
+func portOwners(port int) ([]string, error) {
+	output, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return nil, fmt.Errorf("执行 lsof 失败：%w", err)
+	}
+	// 输出为 p<PID> 与 c<进程名> 交替的行
+	var owners []string
+	pid := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p"):
+			pid, _ = strconv.Atoi(line[1:])
+		case strings.HasPrefix(line, "c") && pid > 0:
+			owners = append(owners, owner(line[1:], pid))
+			pid = 0
+		}
+	}
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("没有找到监听端口 %d 的进程", port)
+	}
+	return owners, nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// WSAEADDRINUSE
+var errAddrInUse error = syscall.Errno(10048)
+
+// portOwners 从 netstat 找到监听该端口的进程，再用 tasklist 查询进程名
+func portOwners(port int) ([]string, error) {
+	output, err := exec.Command("netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return nil, fmt.Errorf("执行 netstat 失败：%w", err)
+	}
+	output6, err := exec.Command("netstat", "-ano", "-p", "TCPv6").Output()
+	if err == nil {
+		output = append(output, output6...)
+	}
+
+	var owners []string
+	seen := make(map[int]bool)
+	suffix := ":" + strconv.Itoa(port)
+	for _, line := range strings.Split(string(output), "\n") {
+		// 协议  本地地址  外部地址  状态  PID，状态在非英文系统上会被翻译，按外部地址的端口为0判断监听
+		fields := strings.Fields(line)
+		if len(fields) != 5 || !strings.HasPrefix(fields[0], "TCP") || !strings.HasSuffix(fields[1], suffix) || !strings.HasSuffix(fields[2], ":0") {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		owners = append(owners, owner(processName(pid), pid))
+	}
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("没有找到监听端口 %d 的进程", port)
+	}
+	return owners, nil
+}
+
+// processName 使用 tasklist 查询进程名，查询失败时为空
+func processName(pid int) string {
+	output, err := exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", pid), "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return ""
+	}
+	record, err := csv.NewReader(strings.NewReader(string(output))).Read()
+	if err != nil || len(record) < 2 || record[1] != strconv.Itoa(pid) {
+		return ""
+	}
+	return record[0]
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"idv-login-go/harController"
+	"idv-login-go/rules"
+	"idv-login-go/upstreamController"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// hopHeaders 逐跳请求头，不应转发给上游
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// handleRewrite 按匹配的规则改写请求，代理后再改写响应
+// 上游的状态码和响应头（逐跳响应头除外）原样返回，重定向不会被跟随而是直接交给客户端
+// 没有响应规则时响应体也直接透传
+func (s *Server) handleRewrite(c *gin.Context) {
+	u := s.route(c.Request)
+	matched := s.rules.Match(u.host, c.Request.Method, c.Request.URL.Path)
+	c.Set(routeKey, routeName(matched))
+
+	// 按规则改写请求
+	rw, err := rules.NewRequest(c.Request)
+	if err != nil {
+		s.handleError(c.Writer, c.Request, err)
+		return
+	}
+	for _, rule := range matched {
+		if err := rule.ApplyRequest(rw); err != nil {
+			log.Errorf("改写请求失败：%v", err)
+			continue
+		}
+		log.Debugf("已应用请求规则：%s", rule.Name)
+	}
+
+	// 开启HAR记录时记录改写前后的请求与响应
+	transport := u.transport
+	var w http.ResponseWriter = c.Writer
+	if s.recorder != nil {
+		x := s.recorder.Begin(c.Request, rw.Original())
+		transport = x.Transport(transport)
+		hw := x.ResponseWriter(c.Writer)
+		w = hw
+		defer x.Finish(hw)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			s.rewriteRequest(pr, u, rw, matched)
+		},
+		Transport:    transport,
+		ErrorHandler: s.handleError,
+	}
+	if hasResponseRules(matched) {
+		proxy.ModifyResponse = func(rsp *http.Response) error {
+			return s.modifyResponse(rsp, matched)
+		}
+	}
+	proxy.ServeHTTP(w, c.Request)
+}
+
+// rewriteRequest 将改写后的请求发往对应主机名的上游
+func (s *Server) rewriteRequest(pr *httputil.ProxyRequest, u *upstream, rw *rules.Request, matched []*rules.Rule) {
+	pr.SetURL(u.urlRedirect)
+	pr.Out.URL.RawQuery = rw.Query.Encode()
+
+	pr.Out.Header = rw.Header
+	removeHopHeaders(pr.Out.Header)
+	if hasResponseBodyRules(matched) {
+		// 需要改写响应体时不接受压缩，由传输层负责解压
+		pr.Out.Header.Del("Accept-Encoding")
+	}
+
+	body := rw.Body()
+	pr.Out.Body = io.NopCloser(bytes.NewReader(body))
+	pr.Out.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		pr.Out.Body = http.NoBody
+	}
+	pr.Out.Header.Del("Content-Length")
+}
+
+// modifyResponse 按规则修改响应体与响应头
+func (s *Server) modifyResponse(rsp *http.Response, matched []*rules.Rule) error {
+	if hasResponseBodyRules(matched) {
+		if err := s.modifyResponseBody(rsp, matched); err != nil {
+			return err
+		}
+	}
+	for _, r := range matched {
+		r.ApplyResponseHeader(rsp.Header)
+	}
+	return nil
+}
+
+// modifyResponseBody 按规则修改body，只有JSON响应才会被缓冲和解析
+func (s *Server) modifyResponseBody(rsp *http.Response, matched []*rules.Rule) error {
+	mediaType, _, _ := mime.ParseMediaType(rsp.Header.Get("Content-Type"))
+	strict := isJSONType(mediaType)
+	if !strict && !maybeJSONType(mediaType) {
+		return nil
+	}
+	if encoding := rsp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		log.Warnf("响应经过 %s 编码，跳过改写", encoding)
+		return nil
+	}
+
+	data, err := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		return err
+	}
+	setBody(rsp, data)
+
+	var newBody interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&newBody); err != nil {
+		if strict {
+			log.Errorf("解析响应失败，跳过改写：%v", err)
+		}
+		return nil
+	}
+
+	// 处理
+	for _, r := range matched {
+		newBody, err = r.ApplyResponse(newBody)
+		if err != nil {
+			log.Errorf("改写响应失败：%v", err)
+			return nil
+		}
+		log.Debugf("已应用响应规则：%s", r.Name)
+	}
+
+	newData, err := json.Marshal(newBody)
+	if err != nil {
+		log.Errorf("序列化响应失败：%v", err)
+		return nil
+	}
+	setBody(rsp, newData)
+	return nil
+}
+
+// handleError 请求上游失败
+func (s *Server) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	reason := err.Error()
+	// 上游证书校验失败时不转发任何数据，提示可能被劫持
+	var verifyErr *upstreamController.VerifyError
+	if errors.As(err, &verifyErr) {
+		status = http.StatusBadGateway
+		reason = verifyErr.Error()
+	}
+	// 回放模式下没有匹配的记录
+	var missErr *harController.MissError
+	if errors.As(err, &missErr) {
+		status = http.StatusBadGateway
+		reason = missErr.Error()
+	}
+	upstreamErrors.Inc(s.route(r).host, errorKind(err))
+	log.Errorf("请求失败：%v", err)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(gin.H{"reason": reason})
+}
+
+// hasResponseRules 是否存在需要改写响应的规则
+func hasResponseRules(matched []*rules.Rule) bool {
+	for _, r := range matched {
+		if len(r.Response) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasResponseBodyRules 是否存在需要改写响应体的规则
+func hasResponseBodyRules(matched []*rules.Rule) bool {
+	for _, r := range matched {
+		if r.HasResponseBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// removeHopHeaders 移除逐跳请求头以及Connection中声明的请求头
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// setBody 替换响应体并更新长度
+func setBody(rsp *http.Response, data []byte) {
+	rsp.Body = io.NopCloser(bytes.NewReader(data))
+	rsp.ContentLength = int64(len(data))
+	rsp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+// isJSONType 是否为JSON类型
+func isJSONType(mediaType string) bool {
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// maybeJSONType 部分接口以文本类型返回JSON，解析失败时原样返回
+func maybeJSONType(mediaType string) bool {
+	return mediaType == "" || mediaType == "text/plain" || mediaType == "text/html"
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RequestRecord 一次代理的概要
+type RequestRecord struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Host     string    `json:"host"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Duration float64   `json:"durationMs"`
+	Client   string    `json:"client"`
+}
+
+// Counts 请求计数
+type Counts struct {
+	Total    int64            `json:"total"`
+	Errors   int64            `json:"errors"` // 状态码不小于500
+	ByStatus map[string]int64 `json:"byStatus"`
+	ByHost   map[string]int64 `json:"byHost"`
+}
+
+// Stats 请求计数与最近的请求，重启代理服务器后保留
+type Stats struct {
+	mu     sync.Mutex
+	counts Counts
+	recent []RequestRecord
+	next   int
+	full   bool
+}
+
+func NewStats(size int) *Stats {
+	if size <= 0 {
+		size = 100
+	}
+	return &Stats{
+		counts: Counts{ByStatus: make(map[string]int64), ByHost: make(map[string]int64)},
+		recent: make([]RequestRecord, size),
+	}
+}
+
+func (s *Stats) add(r RequestRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts.Total++
+	if r.Status >= 500 {
+		s.counts.Errors++
+	}
+	s.counts.ByStatus[statusClass(r.Status)]++
+	s.counts.ByHost[r.Host]++
+	s.recent[s.next] = r
+	s.next = (s.next + 1) % len(s.recent)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Counts 请求计数的快照
+func (s *Stats) Counts() Counts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := Counts{Total: s.counts.Total, Errors: s.counts.Errors, ByStatus: make(map[string]int64), ByHost: make(map[string]int64)}
+	for k, v := range s.counts.ByStatus {
+		counts.ByStatus[k] = v
+	}
+	for k, v := range s.counts.ByHost {
+		counts.ByHost[k] = v
+	}
+	return counts
+}
+
+// Recent 最近的 n 次代理，从新到旧；n 不大于0时返回全部保留的记录
+func (s *Stats) Recent(n int) []RequestRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := s.next
+	if s.full {
+		count = len(s.recent)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	records := make([]RequestRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		records = append(records, s.recent[(s.next-i+len(s.recent))%len(s.recent)])
+	}
+	return records
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
@@ -1,15 +1,16 @@
+//go:build !notray
+
 package main
 
 import (
+	"fmt"
 	"github.com/getlantern/systray"
-	"idv-login-go/certController"
-	"idv-login-go/constants"
-	"idv-login-go/dnsController"
-	"idv-login-go/hostsController"
+	"idv-login-go/app"
 	"idv-login-go/icon"
-	"idv-login-go/server"
+	"idv-login-go/upstreamController"
 	"idv-login-go/windowController"
 	"os"
+	"strings"
 )
 
 type tray struct {
@@ -18,40 +19,34 @@ type tray struct {
 	mStop         *systray.MenuItem
 	mRestart      *systray.MenuItem
 	mToggleWindow *systray.MenuItem
-	serv          *server.Server
-	shutChan      chan bool
+	mUninstall    *systray.MenuItem
+	mUpstream     *systray.MenuItem
+	app           *app.App
+	stopAdmin     func()
+}
+
+// runTray 隐藏控制台窗口并运行托盘，托盘退出后返回 true
+func runTray() bool {
+	if !recoverHosts() {
+		os.Exit(1)
+	}
+	windowController.GetWindowController().HideWindow()
+	newTray().run()
+	return true
 }
 
 func newTray() *tray {
-	return &tray{}
+	return &tray{app: app.New()}
 }
-func (t *tray) start() {
-	t.mStart.Disable()
-	t.mStop.Enable()
 
-	if !t.init() { // 启动失败
-		t.mStart.Enable()
-	}
+// start 启动失败的原因已由 app 记录
+func (t *tray) start() {
+	t.app.Start()
 }
 
+// stop 关闭代理服务器，移除DNS
 func (t *tray) stop() {
-	t.mStart.Enable()
-	t.mStop.Disable()
-
-	// 关闭代理服务器，移除DNS
-	select {
-	case t.shutChan <- true:
-	default: // 防止阻塞
-	}
-	// 进行hosts操作
-	hostC := hostsController.New()
-	if !hostC.IsWritable() {
-		log.Info("文件不可写，请关闭杀毒软件或使用管理员权限运行本程序")
-	}
-	if hostC.Exist() {
-		hostC.Remove()
-		log.Info("hosts移除完成")
-	}
+	t.app.Stop()
 }
 
 func (t *tray) run() {
@@ -60,83 +55,66 @@ func (t *tray) run() {
 func (t *tray) onReady() {
 	log.Info("程序启动")
 	t.createMenuListening()
+	go t.listenEvents()
+	t.stopAdmin = startAdmin(t.app)
 	t.start() // 默认进行启动
 }
 
-func (t *tray) init() bool {
-	// 进行hosts操作
-	hostC := hostsController.New()
-	if !hostC.IsWritable() {
-		log.Info("文件不可写，请关闭杀毒软件或使用管理员权限运行本程序")
-		return false
-	}
-	if !hostC.Exist() {
-		log.Info("hosts中不存在，添加")
-		hostC.Add()
-	}
-	log.Info("hosts准备完成")
-
-	// 检查证书是否存在
-
-	if err := func() error {
-		if _, errCaCert := os.Stat(constants.CaPath); errCaCert != nil {
-			return errCaCert
-		}
-		if _, errWebCert := os.Stat(constants.CertPath); errWebCert != nil {
-			return errWebCert
-		}
-		if _, errKey := os.Stat(constants.KeyPath); errKey != nil {
-			return errKey
-		}
-		return nil
-	}(); os.IsNotExist(err) {
-		// 生成证书
-		certM := certController.New()
-		certM.GenerateCA()
-		certM.GenerateCert([]string{conf.String("host")})
-
-		// 导出证书和key
-		certM.ExportCert(constants.CaPath, certM.CaCert)
-		certM.ExportCert(constants.CertPath, certM.WebCert)
-		certM.ExportKey(constants.KeyPath)
-
-		// 导入CA证书
-		if done, err := certM.ImportToRoot(constants.CaPath); !done {
-			// 删除证书文件
-			os.Remove(constants.CaPath)
-			os.Remove(constants.CertPath)
-			os.Remove(constants.KeyPath)
-
-			log.Fatalf("导入CA证书失败：%v", err)
-			return false
+// listenEvents 按运行状态更新菜单
+func (t *tray) listenEvents() {
+	events, _ := t.app.Subscribe()
+	for e := range events {
+		switch e.Kind {
+		case app.EventState:
+			t.updateMenu(e.State)
+		case app.EventUpstream:
+			t.updateUpstream(t.app.Upstreams())
 		}
 	}
-	log.Infof("证书准备完成")
+}
 
-	// 解析DNS
-	dnsC := dnsController.NewDnsController()
-	ip, err := dnsC.Resolve()
-	if err != nil {
-		log.Errorf("DNS解析失败：%v\n将使用默认IP", err)
-		ip = conf.String("defaultIP")
+func (t *tray) updateMenu(state app.State) {
+	switch state {
+	case app.StateRunning:
+		t.mStart.Disable()
+		t.mStop.Enable()
+	case app.StateStarting, app.StateStopping:
+		t.mStart.Disable()
+		t.mStop.Disable()
+	default:
+		t.mStart.Enable()
+		t.mStop.Disable()
 	}
-	log.Infof("DNS解析结果：%s", ip)
-
-	// 创建一个 channel 用于发送终止信号
-	t.shutChan = make(chan bool)
+}
 
-	go func() { // 启动代理服务器
-		t.serv = server.NewServer(conf.String("host"), ip)
-		t.serv.Run(t.shutChan)
-	}()
-	return true
+// updateUpstream 在托盘中显示上游状态，多个主机名时显示默认上游，其余在提示中
+func (t *tray) updateUpstream(pools []*upstreamController.Pool) {
+	if len(pools) == 0 {
+		t.mUpstream.SetTitle("上游：未启动")
+		t.mUpstream.SetTooltip("当前使用的上游IP")
+		return
+	}
+	title := "上游：" + pools[0].Status()
+	if len(pools) > 1 {
+		title = fmt.Sprintf("%s（共 %d 个主机）", title, len(pools))
+	}
+	t.mUpstream.SetTitle(title)
+	lines := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		lines = append(lines, pool.Host()+"："+pool.Status())
+	}
+	t.mUpstream.SetTooltip(strings.Join(lines, "\n"))
 }
 
 func (t *tray) createMenuListening() {
+	t.mUpstream = systray.AddMenuItem("上游：未启动", "当前使用的上游IP")
+	t.mUpstream.Disable()
+	systray.AddSeparator()
 	t.mStart = systray.AddMenuItem("启动", "启动")
 	t.mStop = systray.AddMenuItem("停止", "停止")
 	t.mRestart = systray.AddMenuItem("重启", "重启")
 	t.mToggleWindow = systray.AddMenuItem("显示窗口", "显示窗口")
+	t.mUninstall = systray.AddMenuItem("卸载", "移除CA证书、程序生成的文件与hosts记录后退出")
 	t.mQuit = systray.AddMenuItem("退出", "退出")
 
 	systray.SetIcon(icon.Icon)
@@ -151,8 +129,7 @@ func (t *tray) createMenuListening() {
 			case <-t.mStop.ClickedCh:
 				t.stop()
 			case <-t.mRestart.ClickedCh:
-				t.stop()
-				t.start()
+				t.app.Restart()
 			case <-t.mToggleWindow.ClickedCh:
 				wC := windowController.GetWindowController()
 				wC.ToggleWindow()
@@ -161,6 +138,11 @@ func (t *tray) createMenuListening() {
 				} else {
 					t.mToggleWindow.SetTitle("显示窗口")
 				}
+			case <-t.mUninstall.ClickedCh:
+				t.stop()
+				uninstall()
+				systray.Quit()
+				return
 			case <-t.mQuit.ClickedCh:
 				systray.Quit()
 				return
@@ -170,5 +152,8 @@ func (t *tray) createMenuListening() {
 }
 
 func (t *tray) onExit() {
+	if t.stopAdmin != nil {
+		t.stopAdmin()
+	}
 	t.stop()
 }
@@ -0,0 +1,8 @@
+//go:build notray
+
+package main
+
+// runTray 使用 notray 标签编译时没有托盘前端，返回 false
+func runTray() bool {
+	return false
+}
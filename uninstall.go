@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"idv-login-go/app"
+	"idv-login-go/certController"
+	"idv-login-go/constants"
+	"idv-login-go/harController"
+	"idv-login-go/hostsController"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uninstallStep 卸载中的一个步骤
+type uninstallStep struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Error  string `json:"error,omitempty"`
+}
+
+// uninstallReport 卸载报告
+type uninstallReport struct {
+	Time  time.Time       `json:"time"`
+	Steps []uninstallStep `json:"steps"`
+}
+
+// add 记录步骤并写入日志
+func (r *uninstallReport) add(action string, target string, err error) {
+	step := uninstallStep{Action: action, Target: target}
+	if err != nil {
+		step.Error = err.Error()
+		log.Errorf("%s %s 失败：%v", action, target, err)
+	} else {
+		log.Infof("%s %s 完成", action, target)
+	}
+	r.Steps = append(r.Steps, step)
+}
+
+// failed 是否有步骤失败
+func (r *uninstallReport) failed() bool {
+	for _, step := range r.Steps {
+		if step.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// uninstall 移除导入的CA证书、程序生成的文件与hosts记录，使系统恢复原状
+func uninstall() *uninstallReport {
+	log.Info("开始卸载")
+	report := &uninstallReport{Time: time.Now()}
+
+	uninstallCA(report)
+
+	// 删除证书文件
+	for _, fn := range []string{constants.CaPath, constants.CaKeyPath, constants.CertPath, constants.KeyPath, constants.TrustRecordPath, constants.DnsCachePath, constants.HostsJournalPath, constants.AdminTokenPath} {
+		err := os.Remove(fn)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		report.add("删除文件", fn, err)
+	}
+
+	// 移除hosts
+	hostC := hostsController.New()
+	if hostC.Managed() {
+		var err error
+		if !hostC.Remove() {
+			err = errors.New("hosts 不可写")
+		}
+		report.add("移除hosts", strings.Join(conf.Hosts(), ", "), err)
+	}
+
+	// 删除hosts备份与HAR记录
+	removeDir(report, constants.HostsBackupDir)
+	removeHAR(report, conf.String("harDir"))
+
+	// 保存卸载报告
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(constants.UninstallReportPath, data, 0644)
+	}
+	if err != nil {
+		log.Errorf("保存卸载报告失败：%v", err)
+	} else {
+		log.Infof("卸载报告已保存到 %s", constants.UninstallReportPath)
+	}
+	return report
+}
+
+// removeTrustedCA 重新生成CA前从信任库移除已导入的CA，成功后删除安装记录
+func removeTrustedCA() bool {
+	_, recordErr := os.Stat(constants.TrustRecordPath)
+	_, caErr := os.Stat(constants.CaPath)
+	if recordErr != nil && caErr != nil {
+		// 没有导入过CA
+		return true
+	}
+	report := &uninstallReport{Time: time.Now()}
+	uninstallCA(report)
+	if report.failed() {
+		log.Error("移除已导入的CA证书失败，未重新生成")
+		return false
+	}
+	if err := os.Remove(constants.TrustRecordPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf("删除安装记录失败：%v", err)
+		return false
+	}
+	return true
+}
+
+// uninstallCA 按安装记录从信任库移除CA证书，没有记录时按配置的信任库查找
+func uninstallCA(report *uninstallReport) {
+	records, err := certController.LoadInstallRecords(constants.TrustRecordPath)
+	if err != nil {
+		report.add("读取安装记录", constants.TrustRecordPath, err)
+	}
+
+	for _, record := range records {
+		ca, err := record.Certificate()
+		if err != nil {
+			report.add("解析CA证书", constants.TrustRecordPath, err)
+			continue
+		}
+		for _, name := range record.Stores {
+			store, err := certController.FindTrustStore(name, app.TrustOptions())
+			if err == nil {
+				err = store.Uninstall(ca)
+			}
+			report.add("移除CA证书", caTarget(name, ca), err)
+		}
+	}
+	if len(records) > 0 {
+		return
+	}
+
+	// 旧版本没有安装记录
+	stores, err := certController.NewTrustStores(conf.Strings("trustStores"), app.TrustOptions())
+	if err != nil {
+		report.add("初始化信任库", "", err)
+		return
+	}
+	ca, err := certController.LoadCert(constants.CaPath)
+	if err != nil {
+		// 没有CA证书时按名称移除
+		ca = nil
+	}
+	for _, store := range stores {
+		if ca != nil {
+			if ok, _ := store.Contains(ca); !ok {
+				continue
+			}
+		}
+		report.add("移除CA证书", caTarget(store.Name(), ca), store.Uninstall(ca))
+	}
+}
+
+// removeDir 删除本程序的目录及其中的文件
+func removeDir(report *uninstallReport, dir string) {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	report.add("删除目录", dir, os.RemoveAll(dir))
+}
+
+// removeHAR 删除 harDir 中本程序写入的HAR文件，目录为空时一并删除
+// harDir 可由用户指定为已有的目录，不能删除其中的其他文件
+func removeHAR(report *uninstallReport, dir string) {
+	if dir == "" {
+		return
+	}
+	names, err := filepath.Glob(filepath.Join(dir, harController.FilePattern))
+	if err != nil {
+		report.add("删除HAR记录", dir, err)
+		return
+	}
+	if len(names) > 0 {
+		var errs []error
+		for _, name := range names {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		report.add("删除HAR记录", fmt.Sprintf("%s（%d 个文件）", dir, len(names)), errors.Join(errs...))
+	}
+	// 目录中还有其他文件时删除失败，保留目录
+	if err := os.Remove(dir); err == nil {
+		report.add("删除目录", dir, nil)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Infof("保留目录 %s：%v", dir, err)
+	}
+}
+
+func caTarget(store string, ca *x509.Certificate) string {
+	if ca == nil {
+		return store
+	}
+	return fmt.Sprintf("%s（序列号 %s）", store, ca.SerialNumber.Text(16))
+}
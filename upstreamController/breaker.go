@@ -0,0 +1,48 @@
+package upstreamController
+
+import "time"
+
+// BreakerState 熔断器状态
+type BreakerState string
+
+const (
+	StateClosed   BreakerState = "closed"    // 正常
+	StateOpen     BreakerState = "open"      // 已熔断，冷却期内不再使用
+	StateHalfOpen BreakerState = "half-open" // 冷却结束，允许尝试，成功后恢复
+)
+
+// available 候选IP当前是否可以使用，冷却结束的IP转为半开状态，调用方需持有锁
+func (p *Pool) available(c *Candidate, now time.Time) bool {
+	switch c.State {
+	case StateOpen:
+		if now.Before(c.OpenUntil) {
+			return false
+		}
+		c.State = StateHalfOpen
+		log.Infof("上游 %s 熔断冷却结束，尝试恢复", c.IP)
+		return true
+	default:
+		return true
+	}
+}
+
+// recordFailure 记录一次失败，连续失败达到阈值或半开状态下失败时熔断，调用方需持有锁
+func (p *Pool) recordFailure(c *Candidate, err error) {
+	c.Failures++
+	c.LastError = err.Error()
+	if c.State == StateHalfOpen || (c.State == StateClosed && c.Failures >= p.opts.BreakerThreshold) {
+		c.State = StateOpen
+		c.OpenUntil = time.Now().Add(p.opts.BreakerCooldown)
+		log.Warnf("上游 %s 连续失败 %d 次，熔断 %s", c.IP, c.Failures, p.opts.BreakerCooldown)
+	}
+}
+
+// recordSuccess 记录一次成功，清除失败次数并关闭熔断，调用方需持有锁
+func (p *Pool) recordSuccess(c *Candidate) {
+	if c.State != StateClosed {
+		log.Infof("上游 %s 已恢复", c.IP)
+	}
+	c.State = StateClosed
+	c.Failures = 0
+	c.LastError = ""
+}
@@ -0,0 +1,247 @@
+package upstreamController
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"idv-login-go/logger"
+	"net"
+	"sync"
+	"time"
+)
+
+var log *logrus.Logger
+
+// Options 上游选项
+type Options struct {
+	Port             string         // 上游端口，默认443
+	ProbeTimeout     time.Duration  // 单次探测超时时间
+	ProbeCount       int            // 排序时每个IP的探测次数
+	DialTimeout      time.Duration  // 转发请求时单个IP的连接超时时间
+	HealthInterval   time.Duration  // 后台健康检查间隔，为0时不检查
+	BreakerThreshold int            // 连续失败多少次后熔断
+	BreakerCooldown  time.Duration  // 熔断后的冷却时间
+	Retries          int            // 幂等请求失败后换IP重试的次数
+	Pins             []string       // SPKI固定列表（sha256/<base64>），为空时只校验证书链与主机名
+	RootCAs          *x509.CertPool // 校验上游证书的根证书，为空时使用系统根证书
+}
+
+// Candidate 候选IP及其状态
+type Candidate struct {
+	IP        string
+	Latency   time.Duration // 握手延迟，后台检查时取滑动平均
+	Probes    int           // 累计探测次数
+	Successes int           // 累计探测成功次数
+	Failures  int           // 连续失败次数
+	LastError string
+	State     BreakerState
+	OpenUntil time.Time // 熔断冷却结束时间
+}
+
+// Pool 真实服务器的候选IP，按延迟排序，转发请求时优先使用最快的IP，失败时依次尝试下一个
+// 后台定期探测全部IP，连续失败的IP会被熔断一段时间
+type Pool struct {
+	host string
+	port string
+	opts Options
+
+	pins [][]byte
+
+	mu         sync.Mutex
+	candidates []*Candidate
+	onChange   func([]Candidate)
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewPool host 为真实主机名，用作SNI；ips 为候选IP
+func NewPool(host string, ips []string, opts Options) (*Pool, error) {
+	log = logger.GetLogger()
+	if opts.Port == "" {
+		opts.Port = "443"
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = 3 * time.Second
+	}
+	if opts.ProbeCount <= 0 {
+		opts.ProbeCount = 1
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = 3
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = 30 * time.Second
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+
+	pins, err := parsePins(opts.Pins)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pool{host: host, port: opts.Port, opts: opts, pins: pins}
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		p.candidates = append(p.candidates, &Candidate{IP: ip, State: StateClosed})
+	}
+	if len(p.candidates) == 0 {
+		return nil, errors.New("没有可用的上游IP")
+	}
+	return p, nil
+}
+
+// Host 真实主机名
+func (p *Pool) Host() string {
+	return p.host
+}
+
+// SetOnChange 设置状态变化时的回调，用于托盘显示
+func (p *Pool) SetOnChange(fn func([]Candidate)) {
+	p.mu.Lock()
+	p.onChange = fn
+	p.mu.Unlock()
+}
+
+// Best 当前最优的可用IP，全部熔断时返回排在最前的IP
+func (p *Pool) Best() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, c := range p.candidates {
+		if c.State != StateOpen || !now.Before(c.OpenUntil) {
+			return c.IP
+		}
+	}
+	return p.candidates[0].IP
+}
+
+// Candidates 候选IP的快照，按优先级排序
+func (p *Pool) Candidates() []Candidate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.snapshot()
+}
+
+// Status 状态摘要，如 "1.2.3.4 12ms，可用 2/3"
+func (p *Pool) Status() string {
+	candidates := p.Candidates()
+	var usable int
+	for _, c := range candidates {
+		if c.State != StateOpen {
+			usable++
+		}
+	}
+	best := candidates[0]
+	if best.State == StateOpen {
+		return fmt.Sprintf("全部熔断，可用 0/%d", len(candidates))
+	}
+	return fmt.Sprintf("%s %s，可用 %d/%d", best.IP, best.Latency.Round(time.Millisecond), usable, len(candidates))
+}
+
+// MarkFailed 记录一次请求失败，该IP会排到后面，连续失败时熔断
+func (p *Pool) MarkFailed(ip string, err error) {
+	p.update(ip, func(c *Candidate) {
+		log.Warnf("上游 %s 请求失败：%v", ip, err)
+		p.recordFailure(c, err)
+	})
+}
+
+// MarkSuccess 记录一次请求成功
+func (p *Pool) MarkSuccess(ip string) {
+	p.update(ip, func(c *Candidate) {
+		if c.State != StateClosed || c.Failures > 0 {
+			p.recordSuccess(c)
+		}
+	})
+}
+
+// update 修改候选IP后重新排序，状态变化时通知
+func (p *Pool) update(ip string, fn func(c *Candidate)) {
+	p.mu.Lock()
+	var changed bool
+	for _, c := range p.candidates {
+		if c.IP == ip {
+			before := *c
+			fn(c)
+			changed = before.State != c.State || before.Failures != c.Failures
+			break
+		}
+	}
+	p.sort()
+	notify, list := p.onChange, p.snapshot()
+	p.mu.Unlock()
+
+	if changed && notify != nil {
+		notify(list)
+	}
+}
+
+// DialTLSContext 用于HTTP客户端，忽略 addr，按优先级依次连接未熔断的IP并完成TLS握手
+// 全部熔断时仍会依次尝试，避免完全无法登录
+func (p *Pool) DialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var errs []error
+	for _, ip := range p.dialOrder() {
+		dialCtx, cancel := context.WithTimeout(ctx, p.opts.DialTimeout)
+		conn, err := p.handshake(dialCtx, ip)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s：%w", ip, err))
+			// 请求被取消或超时不算上游失败，不影响熔断与优先级
+			if ctx.Err() != nil {
+				break
+			}
+			p.MarkFailed(ip, err)
+			continue
+		}
+		p.MarkSuccess(ip)
+		return conn, nil
+	}
+	return nil, fmt.Errorf("连接 %s 失败：%w", p.host, errors.Join(errs...))
+}
+
+// dialOrder 连接顺序，可用的IP在前，熔断中的IP在后
+func (p *Pool) dialOrder() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var usable, open []string
+	for _, c := range p.candidates {
+		if p.available(c, now) {
+			usable = append(usable, c.IP)
+		} else {
+			open = append(open, c.IP)
+		}
+	}
+	return append(usable, open...)
+}
+
+// snapshot 复制候选IP，调用方需持有锁
+func (p *Pool) snapshot() []Candidate {
+	list := make([]Candidate, len(p.candidates))
+	for i, c := range p.candidates {
+		list[i] = *c
+	}
+	return list
+}
+
+// tlsConfig 与上游握手使用的TLS配置，连接的是IP，但按真实主机名发送SNI并校验证书
+func (p *Pool) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:       p.host,
+		RootCAs:          p.opts.RootCAs,
+		NextProtos:       []string{"http/1.1"},
+		VerifyConnection: p.verifyPins,
+	}
+}
@@ -0,0 +1,181 @@
+package upstreamController
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Probe 与 ip 完成一次TCP连接与TLS握手，返回耗时
+func (p *Pool) Probe(ctx context.Context, ip string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.ProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := p.handshake(ctx, ip)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+// Rank 对全部候选IP探测 ProbeCount 次，按成功率与平均延迟排序
+func (p *Pool) Rank(ctx context.Context) {
+	p.probeAll(ctx, p.opts.ProbeCount)
+	for i, c := range p.Candidates() {
+		if c.Successes == 0 {
+			log.Infof("上游候选 %d：%s 不可用：%s", i+1, c.IP, c.LastError)
+		} else {
+			log.Infof("上游候选 %d：%s 延迟 %s", i+1, c.IP, c.Latency.Round(time.Millisecond))
+		}
+	}
+}
+
+// Start 启动后台健康检查
+func (p *Pool) Start() {
+	if p.opts.HealthInterval <= 0 {
+		return
+	}
+	p.mu.Lock()
+	if p.stop != nil {
+		p.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	p.stop = stop
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.opts.HealthInterval)
+		defer ticker.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stop
+			cancel()
+		}()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.probeAll(ctx, 1)
+				log.Debugf("上游健康检查：%s", p.Status())
+			}
+		}
+	}()
+}
+
+// Stop 停止后台健康检查
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	stop := p.stop
+	p.stop = nil
+	p.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	p.wg.Wait()
+}
+
+// probeAll 并发探测全部候选IP，每个IP探测 count 次
+// 熔断中的IP同样会被探测，探测成功即恢复
+func (p *Pool) probeAll(ctx context.Context, count int) {
+	p.mu.Lock()
+	candidates := append([]*Candidate(nil), p.candidates...)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range candidates {
+		wg.Add(1)
+		go func(c *Candidate) {
+			defer wg.Done()
+			var total time.Duration
+			var success int
+			var lastErr error
+			for i := 0; i < count; i++ {
+				latency, err := p.Probe(ctx, c.IP)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				success++
+				total += latency
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			c.Probes += count
+			c.Successes += success
+			if success > 0 {
+				latency := total / time.Duration(success)
+				if c.Latency > 0 {
+					latency = (c.Latency*3 + latency) / 4
+				}
+				c.Latency = latency
+				p.recordSuccess(c)
+			} else {
+				p.recordFailure(c, lastErr)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.sort()
+	notify, list := p.onChange, p.snapshot()
+	p.mu.Unlock()
+	// 延迟变化也会影响排序，探测后总是通知
+	if notify != nil {
+		notify(list)
+	}
+}
+
+// handshake 连接 ip 并以真实主机名作为SNI完成TLS握手，证书校验失败时返回 *VerifyError
+func (p *Pool) handshake(ctx context.Context, ip string) (*tls.Conn, error) {
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip, p.port))
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, p.tlsConfig())
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		if verifyFailed(err) {
+			verifyErr := &VerifyError{IP: ip, Host: p.host, Err: err}
+			log.Error(verifyErr)
+			return nil, verifyErr
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sort 熔断的排在最后，其次按连续失败次数与延迟排序，调用方需持有锁
+func (p *Pool) sort() {
+	sort.SliceStable(p.candidates, func(i, j int) bool {
+		a, b := p.candidates[i], p.candidates[j]
+		if (a.State == StateOpen) != (b.State == StateOpen) {
+			return b.State == StateOpen
+		}
+		if a.Failures != b.Failures {
+			return a.Failures < b.Failures
+		}
+		// 未探测过的排在已探测的后面
+		if (a.Latency == 0) != (b.Latency == 0) {
+			return a.Latency != 0
+		}
+		return a.Latency < b.Latency
+	})
+}
@@ -0,0 +1,69 @@
+package upstreamController
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+)
+
+// transport 记录每次请求使用的IP，失败时降低其优先级，幂等请求换IP重试
+type transport struct {
+	pool *Pool
+	base http.RoundTripper
+}
+
+// Transport 包装上游的 RoundTripper
+func (p *Pool) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{pool: p, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if idempotent(req) {
+		attempts += t.pool.opts.Retries
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		var ip string
+		ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				ip, _, _ = net.SplitHostPort(info.Conn.RemoteAddr().String())
+			},
+		})
+
+		var rsp *http.Response
+		rsp, err = t.base.RoundTrip(req.WithContext(ctx))
+		if err == nil {
+			if ip != "" {
+				t.pool.MarkSuccess(ip)
+			}
+			return rsp, nil
+		}
+		// 客户端主动断开不算上游失败
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		if ip != "" {
+			// 连接握手时的失败已由 DialTLSContext 记录
+			t.pool.MarkFailed(ip, err)
+		}
+		if i+1 < attempts {
+			log.Warnf("%s %s 失败，换IP重试：%v", req.Method, req.URL.Path, err)
+			// 关闭空闲连接，避免重试时复用到同一IP
+			if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+				closer.CloseIdleConnections()
+			}
+		}
+	}
+	return nil, err
+}
+
+// idempotent 只重试没有请求体的 GET/HEAD/OPTIONS
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package upstreamController
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errPinMismatch 证书链中没有公钥与固定列表匹配
+var errPinMismatch = errors.New("证书公钥不在固定列表中")
+
+// VerifyError 上游证书校验失败，连接可能被中间人劫持
+type VerifyError struct {
+	IP   string
+	Host string
+	Err  error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("上游 %s（%s）证书校验失败，连接可能被劫持：%v", e.IP, e.Host, e.Err)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// parsePins 解析SPKI固定列表，格式为 sha256/<base64> 或 <base64>
+func parsePins(pins []string) ([][]byte, error) {
+	hashes := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		if pin == "" {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("无效的SPKI固定值 %q", pin)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// verifyPins 证书链中任意一个证书的公钥与固定列表匹配即可，没有固定列表时不检查
+func (p *Pool) verifyPins(cs tls.ConnectionState) error {
+	if len(p.pins) == 0 {
+		return nil
+	}
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range p.pins {
+				if string(pin) == string(sum[:]) {
+					return nil
+				}
+			}
+		}
+	}
+	return errPinMismatch
+}
+
+// verifyFailed 握手错误是否由证书校验失败引起
+func verifyFailed(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	return errors.As(err, &certErr) || errors.Is(err, errPinMismatch)
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package windowController
+
+import "sync"
+
+var (
+	once     sync.Once
+	instance *WindowController
+)
+
+// WindowController 非Windows平台没有控制台窗口，操作均为空
+type WindowController struct {
+	Status int
+}
+
+func GetWindowController() *WindowController {
+	once.Do(func() {
+		instance = &WindowController{Status: 1}
+	})
+	return instance
+}
+
+func (c *WindowController) HideWindow() {
+	c.Status = 0
+}
+
+func (c *WindowController) ShowWindow() {
+	c.Status = 1
+}
+
+func (c *WindowController) ToggleWindow() {
+	c.Status ^= 1
+}
+
+func (c *WindowController) IsShow() bool {
+	return c.Status == 1
+}